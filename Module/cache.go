@@ -0,0 +1,107 @@
+package module
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cdxCache keeps the raw listing of finished CDX queries on disk, gzip
+// compressed, so a rerun with other extension rules doesn't hit the archive
+// again. Entries are keyed by the full query, so a different domain, scope,
+// date range or server-side filter is a different entry.
+type cdxCache struct {
+	dir     string
+	ttl     time.Duration
+	refresh bool
+}
+
+func (c *cdxCache) path(q cdxQuery) string {
+	return filepath.Join(c.dir, q.key()+".gz")
+}
+
+// replay passes the cached lines of q to emit. It reports false when there
+// is no fresh entry.
+func (c *cdxCache) replay(q cdxQuery, emit func(string)) (int, bool) {
+	if c.ttl <= 0 || c.refresh {
+		return 0, false
+	}
+
+	path := c.path(q)
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	age := time.Since(info.ModTime())
+	if age > c.ttl {
+		return 0, false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return 0, false
+	}
+	defer reader.Close()
+
+	// Lines emitted before a corrupt entry can't be taken back, so the
+	// entry is still used for what it has
+	count, err := scanLines(reader, emit)
+	if err != nil {
+		red.Print("[WARNING] ")
+		fmt.Printf("Cached listing for %s is damaged: %v\n", q.Name, err)
+	}
+	cyan.Print("[INFO] ")
+	fmt.Printf("Using cached listing for %s (%s old)\n", q.Name, age.Round(time.Second))
+	return count, true
+}
+
+// store compresses the finished listing at linesPath into the cache.
+func (c *cdxCache) store(q cdxQuery, linesPath string) error {
+	if c.ttl <= 0 {
+		return nil
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+
+	lines, err := os.Open(linesPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Nothing was listed, cache the empty result
+			lines, err = os.Open(os.DevNull)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	defer lines.Close()
+
+	// Write next to the entry and rename, so readers never see half of it
+	tmpPath := c.path(q) + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(file)
+	_, err = io.Copy(writer, lines)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, c.path(q))
+}
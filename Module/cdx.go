@@ -0,0 +1,491 @@
+package module
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cdxQuery is a single CDX API query, paged through with showNumPages/page.
+type cdxQuery struct {
+	Name     string // label used in messages and resume file names
+	Endpoint string
+	Params   url.Values
+}
+
+func (q cdxQuery) url(extra url.Values) string {
+	params := url.Values{}
+	for k, v := range q.Params {
+		params[k] = v
+	}
+	for k, v := range extra {
+		params[k] = v
+	}
+	return q.Endpoint + "?" + params.Encode()
+}
+
+// key identifies the query for resume state.
+func (q cdxQuery) key() string {
+	sum := sha1.Sum([]byte(q.url(nil)))
+	name := strings.Map(func(r rune) rune {
+		if r == '.' || r == '-' || r == '_' || ('a' <= r && r <= 'z') || ('0' <= r && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(q.Name))
+	return name + "-" + hex.EncodeToString(sum[:6])
+}
+
+// addDateRange adds the from/to bounds of target to params.
+func addDateRange(params url.Values, target Target, cfg *Settings) {
+	from, to := target.dateRange(cfg)
+	if from != "" {
+		params.Set("from", from)
+	}
+	if to != "" {
+		params.Set("to", to)
+	}
+}
+
+// cdxFilterFields names the fields a CDX server filters on.
+type cdxFilterFields struct {
+	URL    string
+	Status string
+	Mime   string
+}
+
+var (
+	waybackFilterFields     = cdxFilterFields{URL: "original", Status: "statuscode", Mime: "mimetype"}
+	commonCrawlFilterFields = cdxFilterFields{URL: "url", Status: "status", Mime: "mime"}
+)
+
+// addServerFilters adds the configured server-side filters to params. A
+// filter starting with ! keeps only captures that don't match it.
+func addServerFilters(params url.Values, cfg *Settings, fields cdxFilterFields) {
+	add := func(field, pattern string) {
+		if pattern == "" {
+			return
+		}
+		negate := ""
+		if rest, ok := strings.CutPrefix(pattern, "!"); ok {
+			negate, pattern = "!", rest
+		}
+		params.Add("filter", negate+field+":"+pattern)
+	}
+
+	add(fields.Status, cfg.StatusFilter)
+	add(fields.Mime, cfg.MimeFilter)
+	if pattern := cfg.ExtensionRegex(); cfg.ServerSideExtensions && pattern != "" {
+		// The servers match the whole field, case sensitive
+		add(fields.URL, "(?i)(?:"+pattern+")")
+	}
+}
+
+// resumeState is saved after every completed page, next to a file holding
+// the raw lines of the pages read so far.
+type resumeState struct {
+	Query string `json:"query"`
+	Pages int    `json:"pages"`
+	Next  int    `json:"next"`
+}
+
+type cdxPager struct {
+	client      *http.Client
+	retries     int
+	dir         string
+	rateRetries int
+	backoffBase time.Duration
+	maxBackoff  time.Duration
+	cache       *cdxCache
+}
+
+func newCDXPager() (*cdxPager, error) {
+	cfg, err := CurrentSettings()
+	if err != nil {
+		return nil, err
+	}
+	archiveGate.setBudget(cfg.MaxRequests)
+	return &cdxPager{
+		client:      &http.Client{Timeout: time.Duration(cfg.PageTimeout) * time.Second},
+		retries:     cfg.PageRetries,
+		dir:         cfg.ResumeDir,
+		rateRetries: cfg.RateLimitRetries,
+		backoffBase: time.Duration(cfg.BackoffBase) * time.Second,
+		maxBackoff:  time.Duration(cfg.MaxBackoff) * time.Second,
+		cache:       &cdxCache{dir: cfg.CacheDir, ttl: cfg.CacheTTL, refresh: cfg.RefreshCache},
+	}, nil
+}
+
+// stream pages through q and passes every non-empty line to emit. Lines of a
+// page are only emitted once the whole page was read, so a failed page can be
+// retried without duplicates. It returns the number of lines emitted.
+func (p *cdxPager) stream(q cdxQuery, emit func(string)) (int, error) {
+	statePath := filepath.Join(p.dir, q.key()+".json")
+	linesPath := filepath.Join(p.dir, q.key()+".lines")
+
+	if n, ok := p.cache.replay(q, emit); ok {
+		return n, nil
+	}
+
+	state := resumeState{Query: q.url(nil)}
+	count := 0
+
+	if saved, err := loadResumeState(statePath); err == nil && saved.Query == state.Query {
+		n, err := replayLines(linesPath, emit)
+		if err == nil {
+			state = *saved
+			count = n
+			cyan.Print("[INFO] ")
+			fmt.Printf("Resuming %s from page %d/%d\n", q.Name, state.Next+1, state.Pages)
+		}
+	}
+
+	if state.Pages == 0 {
+		pages, err := p.numPages(q)
+		if err != nil {
+			return count, err
+		}
+		state.Pages = pages
+		os.Remove(linesPath)
+	}
+
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return count, err
+	}
+
+	if state.Pages < 0 {
+		// Endpoint without pagination support, read it in one go
+		n, err := p.single(q, linesPath, emit)
+		if err != nil {
+			os.Remove(linesPath)
+			return n, err
+		}
+		p.finish(q, linesPath)
+		return n, nil
+	}
+
+	for page := state.Next; page < state.Pages; page++ {
+		n, err := p.fetchPage(q, page, linesPath, emit)
+		if err != nil {
+			red.Print("[ERROR] ")
+			fmt.Printf("Page %d/%d of %s failed, run again to resume: %v\n", page+1, state.Pages, q.Name, err)
+			return count, err
+		}
+		count += n
+
+		state.Next = page + 1
+		if err := saveResumeState(statePath, &state); err != nil {
+			return count, err
+		}
+	}
+
+	os.Remove(statePath)
+	p.finish(q, linesPath)
+	return count, nil
+}
+
+// finish moves a completed listing into the cache.
+func (p *cdxPager) finish(q cdxQuery, linesPath string) {
+	if err := p.cache.store(q, linesPath); err != nil {
+		red.Print("[WARNING] ")
+		fmt.Printf("Failed to cache listing for %s: %v\n", q.Name, err)
+	}
+	os.Remove(linesPath)
+}
+
+// numPages asks the server how many pages q has. It returns -1 when the
+// server doesn't support paging.
+func (p *cdxPager) numPages(q cdxQuery) (int, error) {
+	body, err := p.get(q.url(url.Values{"showNumPages": {"true"}}))
+	if err != nil {
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && statusErr.code == http.StatusBadRequest {
+			return -1, nil
+		}
+		return 0, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(body, 256))
+	if err != nil {
+		return 0, err
+	}
+	text := strings.TrimSpace(string(data))
+	if text == "" {
+		return 0, nil
+	}
+
+	// pywb based servers answer with {"pages": N, ...}
+	if strings.HasPrefix(text, "{") {
+		var info struct {
+			Pages int `json:"pages"`
+		}
+		if err := json.Unmarshal([]byte(text), &info); err != nil {
+			return -1, nil
+		}
+		return info.Pages, nil
+	}
+	pages, err := strconv.Atoi(text)
+	if err != nil {
+		return -1, nil
+	}
+	return pages, nil
+}
+
+// fetchPage downloads one page to a temporary file, retrying on failure,
+// then appends it to linesPath and emits its lines.
+func (p *cdxPager) fetchPage(q cdxQuery, page int, linesPath string, emit func(string)) (int, error) {
+	tmpPath := linesPath + ".page"
+	defer os.Remove(tmpPath)
+
+	var lastErr error
+	for attempt := 1; attempt <= p.retries; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+		lastErr = p.download(q.url(url.Values{"page": {strconv.Itoa(page)}}), tmpPath)
+		if lastErr == nil || errors.Is(lastErr, errBudgetExhausted) {
+			break
+		}
+	}
+	if errors.Is(lastErr, errBudgetExhausted) {
+		return 0, lastErr
+	}
+	if lastErr != nil {
+		return 0, fmt.Errorf("after %d attempts: %v", p.retries, lastErr)
+	}
+
+	tmp, err := os.Open(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	defer tmp.Close()
+
+	lines, err := os.OpenFile(linesPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer lines.Close()
+
+	return scanLines(io.TeeReader(tmp, lines), emit)
+}
+
+func (p *cdxPager) download(pageURL, path string) error {
+	body, err := p.get(pageURL)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(file, body)
+	return err
+}
+
+// single reads an unpaged query straight from the response, keeping a copy
+// in linesPath.
+func (p *cdxPager) single(q cdxQuery, linesPath string, emit func(string)) (int, error) {
+	body, err := p.get(q.url(nil))
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	lines, err := os.Create(linesPath)
+	if err != nil {
+		return 0, err
+	}
+	defer lines.Close()
+
+	return scanLines(io.TeeReader(body, lines), emit)
+}
+
+// statusError is returned for a response that isn't a CDX listing.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected status " + e.status
+}
+
+// get requests u through the shared archive gate. A 429 or 503 is retried
+// after the server's Retry-After, or an exponential backoff when it sends
+// none. A 404 means no captures and reads as an empty listing.
+func (p *cdxPager) get(u string) (io.ReadCloser, error) {
+	for attempt := 0; ; attempt++ {
+		if err := archiveGate.wait(); err != nil {
+			return nil, err
+		}
+
+		resp, err := p.client.Get(u)
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return resp.Body, nil
+		case http.StatusNotFound:
+			resp.Body.Close()
+			return io.NopCloser(strings.NewReader("")), nil
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			resp.Body.Close()
+			if attempt >= p.rateRetries {
+				return nil, fmt.Errorf("%s after %d retries", resp.Status, attempt)
+			}
+			delay := retryAfter(resp.Header.Get("Retry-After"))
+			if delay <= 0 {
+				delay = p.backoff(attempt)
+			}
+			if delay > p.maxBackoff {
+				delay = p.maxBackoff
+			}
+			red.Print("[WARNING] ")
+			fmt.Printf("Archive returned %s, pausing for %s\n", resp.Status, delay.Round(time.Second))
+			archiveGate.pause(delay)
+		default:
+			resp.Body.Close()
+			return nil, &statusError{code: resp.StatusCode, status: resp.Status}
+		}
+	}
+}
+
+// backoff returns the exponential delay with jitter for a retry attempt.
+func (p *cdxPager) backoff(attempt int) time.Duration {
+	delay := p.backoffBase * time.Duration(1<<uint(attempt))
+	if delay <= 0 || delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay/4)+1))
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP
+// date. It returns 0 when the header is missing or invalid.
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+	return 0
+}
+
+// requestGate is shared by every archive request of a run. It enforces the
+// request budget and holds all requests back while the archive asked us to
+// slow down.
+type requestGate struct {
+	mu          sync.Mutex
+	used        int
+	budget      int
+	pausedUntil time.Time
+}
+
+var archiveGate = &requestGate{}
+
+var errBudgetExhausted = errors.New("archive request budget exhausted, raise MaxRequests in settings.ini")
+
+func (g *requestGate) setBudget(budget int) {
+	g.mu.Lock()
+	g.budget = budget
+	g.mu.Unlock()
+}
+
+// wait blocks while the gate is paused, then takes one request from the
+// budget.
+func (g *requestGate) wait() error {
+	for {
+		g.mu.Lock()
+		delay := time.Until(g.pausedUntil)
+		if delay <= 0 {
+			defer g.mu.Unlock()
+			if g.budget > 0 && g.used >= g.budget {
+				return errBudgetExhausted
+			}
+			g.used++
+			return nil
+		}
+		g.mu.Unlock()
+		time.Sleep(delay)
+	}
+}
+
+func (g *requestGate) pause(delay time.Duration) {
+	g.mu.Lock()
+	if until := time.Now().Add(delay); until.After(g.pausedUntil) {
+		g.pausedUntil = until
+	}
+	g.mu.Unlock()
+}
+
+func scanLines(r io.Reader, emit func(string)) (int, error) {
+	count := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxCDXLine)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		// Skip empty lines
+		if line == "" {
+			continue
+		}
+		count++
+		emit(line)
+	}
+	return count, scanner.Err()
+}
+
+func replayLines(path string, emit func(string)) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer file.Close()
+
+	return scanLines(file, emit)
+}
+
+func loadResumeState(path string) (*resumeState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state resumeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func saveResumeState(path string, state *resumeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
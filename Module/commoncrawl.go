@@ -0,0 +1,114 @@
+package module
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+const CommonCrawlIndexURL = "https://index.commoncrawl.org/collinfo.json"
+
+// CommonCrawlSource lists URLs from the Common Crawl CDX index API.
+type CommonCrawlSource struct {
+	// IndexURL points at the collinfo.json listing of crawl indexes.
+	IndexURL string
+	// Collections is how many of the newest crawls are queried.
+	Collections int
+}
+
+type ccCollection struct {
+	ID     string `json:"id"`
+	CDXAPI string `json:"cdx-api"`
+}
+
+// ccCapture is one line of the index API's JSON output.
+type ccCapture struct {
+	URL       string `json:"url"`
+	Timestamp string `json:"timestamp"`
+	Mime      string `json:"mime"`
+	Status    string `json:"status"`
+	Digest    string `json:"digest"`
+	Length    string `json:"length"`
+}
+
+func (s *CommonCrawlSource) Name() string {
+	return "Common Crawl"
+}
+
+func (s *CommonCrawlSource) Stream(target Target, emit func(CDXRecord)) (int, error) {
+	pager, err := newCDXPager()
+	if err != nil {
+		return 0, err
+	}
+	cfg, _ := CurrentSettings()
+
+	collections, err := s.collections(pager)
+	if err != nil {
+		return 0, err
+	}
+
+	params := url.Values{}
+	params.Add("url", target.queryURL(cfg))
+	params.Add("output", "json")
+	params.Add("fl", "url,timestamp,mime,status,digest,length")
+	addDateRange(params, target, cfg)
+	addServerFilters(params, cfg, commonCrawlFilterFields)
+
+	total := 0
+	for _, c := range collections {
+		count, err := pager.stream(cdxQuery{Name: c.ID + "-" + target.Domain, Endpoint: c.CDXAPI, Params: params}, func(line string) {
+			record, err := parseCCLine(line)
+			if err != nil {
+				return
+			}
+			emit(record)
+		})
+		total += count
+		if err != nil {
+			return total, fmt.Errorf("%s: %v", c.ID, err)
+		}
+	}
+	return total, nil
+}
+
+// collections returns the newest crawl indexes, newest first as listed by
+// collinfo.json.
+func (s *CommonCrawlSource) collections(pager *cdxPager) ([]ccCollection, error) {
+	body, err := pager.get(s.IndexURL)
+	if err != nil {
+		return nil, fmt.Errorf("crawl index list: %v", err)
+	}
+	defer body.Close()
+
+	var collections []ccCollection
+	if err := json.NewDecoder(body).Decode(&collections); err != nil {
+		return nil, fmt.Errorf("crawl index list: %v", err)
+	}
+	if s.Collections > 0 && len(collections) > s.Collections {
+		collections = collections[:s.Collections]
+	}
+	return collections, nil
+}
+
+func parseCCLine(line string) (CDXRecord, error) {
+	var c ccCapture
+	if err := json.Unmarshal([]byte(line), &c); err != nil {
+		return CDXRecord{}, err
+	}
+	if c.URL == "" {
+		return CDXRecord{}, fmt.Errorf("unexpected index line %q", line)
+	}
+
+	length, _ := strconv.ParseInt(c.Length, 10, 64)
+	return CDXRecord{
+		URL:        c.URL,
+		FirstSeen:  c.Timestamp,
+		LastSeen:   c.Timestamp,
+		LastOK:     okTimestamp(c.Timestamp, c.Status),
+		MimeType:   c.Mime,
+		StatusCode: c.Status,
+		Digest:     c.Digest,
+		Length:     length,
+	}, nil
+}
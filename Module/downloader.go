@@ -1,6 +1,7 @@
 package module
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
@@ -25,6 +26,7 @@ type FileMetadata struct {
 	Size     int64
 	Filename string
 	Path     string
+	Source   string
 }
 
 type DownloadManager struct {
@@ -50,7 +52,7 @@ func NewDownloadManager(concurrency int) *DownloadManager {
     }
 }
 
-func (dm *DownloadManager) Download(urls []string) error {
+func (dm *DownloadManager) Download(records []CDXRecord) error {
     if err := os.MkdirAll(dm.OutputDir, 0755); err != nil {
         return err
     }
@@ -59,10 +61,15 @@ func (dm *DownloadManager) Download(urls []string) error {
     failedLogPath := filepath.Join(dm.OutputDir, "failed_downloads.log")
     if _, err := os.Stat(failedLogPath); err == nil {
         if content, err := os.ReadFile(failedLogPath); err == nil {
+            queued := make(map[string]bool, len(records))
+            for _, record := range records {
+                queued[record.FetchURL()] = true
+            }
             previousFailures := strings.Split(string(content), "\n")
             for _, url := range previousFailures {
-                if url = strings.TrimSpace(url); url != "" {
-                    urls = append(urls, url)
+                if url = strings.TrimSpace(url); url != "" && !queued[url] {
+                    queued[url] = true
+                    records = append(records, recordFromFetchURL(url))
                 }
             }
         }
@@ -70,8 +77,15 @@ func (dm *DownloadManager) Download(urls []string) error {
         os.Remove(failedLogPath)
     }
 
+    // Most interesting files first, including retried ones, taking turns
+    // between hosts on ties
+    records = interleaveHosts(rankRecords(records))
+    if cfg, err := CurrentSettings(); err == nil {
+        hostLimits.setLimits(cfg.MaxPerHost, cfg.HostRate)
+    }
+
     // Create job and result queues with limited buffer
-    jobQueue := make(chan string, dm.Concurrency*2)
+    jobQueue := make(chan CDXRecord, dm.Concurrency*2)
     var wg sync.WaitGroup
 
     downloadLoader := loader.New("[INFO] Downloading files")
@@ -79,7 +93,7 @@ func (dm *DownloadManager) Download(urls []string) error {
     defer downloadLoader.Stop()
 
     // Create progress bar for overall progress
-    bar := progressbar.NewOptions(len(urls),
+    bar := progressbar.NewOptions(len(records),
         progressbar.OptionEnableColorCodes(true),
         progressbar.OptionShowBytes(true),
         progressbar.OptionSetWidth(15),
@@ -93,36 +107,28 @@ func (dm *DownloadManager) Download(urls []string) error {
         }),
     )
 
-    // Start worker pool with controlled concurrency
+    // Start worker pool with controlled concurrency, the requests to each
+    // host are limited by hostLimits
     for i := 0; i < dm.Concurrency; i++ {
         wg.Add(1)
         go func(workerID int) {
             defer wg.Done()
-            rateLimiter := time.NewTicker(500 * time.Millisecond)
-            defer rateLimiter.Stop()
-
-            for url := range jobQueue {
-                <-rateLimiter.C
-                if err := dm.downloadFile(url); err != nil {
-                    dm.mu.Lock()
-                    dm.failedURLs = append(dm.failedURLs, url)
-                    dm.mu.Unlock()
+
+            for record := range jobQueue {
+                err := dm.downloadFile(record)
+                dm.setFailed(record.FetchURL(), err != nil)
+                if err != nil {
                     red.Print("[ERROR] ")
-                    fmt.Printf("Worker %d: Failed to download %s: %v\n", workerID, url, err)
+                    fmt.Printf("Worker %d: Failed to download %s: %v\n", workerID, record.FetchURL(), err)
                 }
                 bar.Add(1)
-                time.Sleep(200 * time.Millisecond)
             }
         }(i)
     }
 
-    // Feed URLs to job queue with rate limiting
-    urlFeeder := time.NewTicker(100 * time.Millisecond)
-    defer urlFeeder.Stop()
-
-    for _, url := range urls {
-        <-urlFeeder.C // Rate limit URL feeding
-        jobQueue <- url
+    // Feed URLs to job queue
+    for _, record := range records {
+        jobQueue <- record
     }
     close(jobQueue)
 
@@ -130,21 +136,30 @@ func (dm *DownloadManager) Download(urls []string) error {
     bar.Finish()
 
     // Save failed URLs to a log file and retry if needed
-    if len(dm.failedURLs) > 0 {
+    if failed := dm.FailedURLs(); len(failed) > 0 {
         logFile := filepath.Join(dm.OutputDir, "failed_downloads.log")
-        if err := os.WriteFile(logFile, []byte(strings.Join(dm.failedURLs, "\n")), 0644); err != nil {
+        if err := os.WriteFile(logFile, []byte(strings.Join(failed, "\n")), 0644); err != nil {
             fmt.Printf("Failed to save failed downloads log: %v\n", err)
         } else {
             red.Print("[WARNING] ")
-            fmt.Printf("%d downloads failed. See %s for details.\n", len(dm.failedURLs), logFile)
+            fmt.Printf("%d downloads failed. See %s for details.\n", len(failed), logFile)
         }
     }
 
     return nil
 }
 
-func (dm *DownloadManager) downloadFile(fileURL string) error {
+func (dm *DownloadManager) downloadFile(record CDXRecord) error {
     var lastErr error
+    fileURL := record.FetchURL()
+
+    // Downloads follow the same timeout and redirect policy as validation
+    var checkRedirect func(*http.Request, []*http.Request) error
+    timeout := 30 * time.Second
+    if cfg, err := CurrentSettings(); err == nil {
+        checkRedirect = redirectPolicy(cfg, record.redirectScope())
+        timeout = time.Duration(cfg.Timeout) * time.Second
+    }
 
     for attempt := 0; attempt < dm.maxRetries; attempt++ {
         if attempt > 0 {
@@ -155,18 +170,26 @@ func (dm *DownloadManager) downloadFile(fileURL string) error {
         }
 
         client := &http.Client{
-            Timeout: 30 * time.Second,
-            Transport: &http.Transport{
-                MaxIdleConns:        100,
-                MaxIdleConnsPerHost: 100,
-                IdleConnTimeout:     90 * time.Second,
-                DisableCompression:  true,
+            CheckRedirect: checkRedirect,
+            Transport: &hostTransport{
+                base: &http.Transport{
+                    MaxIdleConns:        100,
+                    MaxIdleConnsPerHost: 100,
+                    IdleConnTimeout:     90 * time.Second,
+                    DisableCompression:  true,
+                },
+                timeout: timeout,
             },
         }
 
         resp, err := client.Get(fileURL)
         if err != nil {
             lastErr = fmt.Errorf("attempt %d: %v", attempt+1, err)
+            // A refused redirect won't change on retry
+            var redirectErr *RedirectError
+            if errors.As(err, &redirectErr) {
+                return lastErr
+            }
             continue
         }
 
@@ -192,8 +215,9 @@ func (dm *DownloadManager) downloadFile(fileURL string) error {
             continue
         }
 
-        // Extract domain and create subdirectory
-        parsedURL, err := url.Parse(fileURL)
+        // Extract domain and create subdirectory, archived copies are stored
+        // under the original host
+        parsedURL, err := url.Parse(record.URL)
         if err != nil {
             return err
         }
@@ -238,6 +262,9 @@ func (dm *DownloadManager) downloadFile(fileURL string) error {
         size, err := io.Copy(io.MultiWriter(file, bar), resp.Body)
         if err != nil {
             // If copy fails, try to remove the partially downloaded file
+            // and free the host for the retry
+            file.Close()
+            resp.Body.Close()
             os.Remove(filePath)
             lastErr = err
             if attempt == dm.maxRetries {
@@ -256,6 +283,7 @@ func (dm *DownloadManager) downloadFile(fileURL string) error {
             Size:     size,
             Filename: filename,
             Path:     filePath,
+            Source:   record.Source,
         })
         dm.mu.Unlock()
 
@@ -263,7 +291,10 @@ func (dm *DownloadManager) downloadFile(fileURL string) error {
         green.Print("[SUCCESS] ")
         fmt.Printf("Downloaded: %s\n", filename)
         fmt.Printf("  Local Path: %s\n", filePath)
-        color.New(color.FgHiBlack).Printf("  Web Path: %s\n", fileURL)
+        color.New(color.FgHiBlack).Printf("  Web Path: %s\n", record.URL)
+        if record.Source == SourceArchive {
+            fmt.Printf("  Source: archive snapshot %s\n", record.LastOK)
+        }
         fmt.Printf("  Size: %s\n", humanize.Bytes(uint64(size)))
         fmt.Printf("  Type: %s\n", resp.Header.Get("Content-Type"))
 
@@ -277,4 +308,30 @@ func (dm *DownloadManager) GetMetadata() []FileMetadata {
 	dm.mu.Lock()
 	defer dm.mu.Unlock()
 	return dm.Metadata
-}
\ No newline at end of file
+}
+
+// setFailed records whether the last download of url failed. A URL whose
+// retry succeeded is no longer failed.
+func (dm *DownloadManager) setFailed(url string, failed bool) {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+
+	for i, u := range dm.failedURLs {
+		if u == url {
+			if !failed {
+				dm.failedURLs = append(dm.failedURLs[:i], dm.failedURLs[i+1:]...)
+			}
+			return
+		}
+	}
+	if failed {
+		dm.failedURLs = append(dm.failedURLs, url)
+	}
+}
+
+// FailedURLs returns the URLs that still could not be downloaded.
+func (dm *DownloadManager) FailedURLs() []string {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	return append([]string(nil), dm.failedURLs...)
+}
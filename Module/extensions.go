@@ -0,0 +1,113 @@
+package module
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Extension matching modes for [FileExtensions].
+const (
+	// MatchExtension compares the real extension of the URL path.
+	MatchExtension = "extension"
+	// MatchRegex runs Pattern over the whole lowercased URL.
+	MatchRegex = "regex"
+)
+
+// parseExtensions reads an extension list, lowercased and without the
+// leading dot.
+func parseExtensions(value string) []string {
+	var exts []string
+	for _, ext := range splitList(strings.ToLower(value)) {
+		exts = append(exts, strings.TrimPrefix(ext, "."))
+	}
+	return exts
+}
+
+// looksLikeRegex reports whether an Extensions value is still a regular
+// expression, as written by older versions of settings.ini.
+func looksLikeRegex(value string) bool {
+	return strings.ContainsAny(value, `\|()[]^$*+?`)
+}
+
+// fileExtension returns the longest configured extension that name ends
+// with, so tar.gz wins over gz, or "" if there is none. A dotfile counts as
+// its own extension, so .env and .htpasswd match env and htpasswd.
+func fileExtension(name string, exts []string) string {
+	name = strings.ToLower(name)
+	found := ""
+	for _, ext := range exts {
+		if len(ext) > len(found) && strings.HasSuffix(name, "."+ext) {
+			found = ext
+		}
+	}
+	return found
+}
+
+// URLExtension returns the configured extension of the file raw points to.
+// Only the last path segment is looked at, so /docs.md/page and /sql/ don't
+// match. With queryValues set, values like ?file=backup.sql count as well.
+func URLExtension(raw string, exts []string, queryValues bool) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	if ext := fileExtension(path.Base(u.Path), exts); ext != "" && !strings.HasSuffix(u.Path, "/") {
+		return ext
+	}
+	if !queryValues {
+		return ""
+	}
+	for _, values := range u.Query() {
+		for _, v := range values {
+			if ext := fileExtension(path.Base(v), exts); ext != "" {
+				return ext
+			}
+		}
+	}
+	return ""
+}
+
+// extensionMatcher builds the URL test of the [FileExtensions] settings.
+func extensionMatcher(cfg *Settings) (func(string) bool, error) {
+	if cfg.ExtensionMode == MatchRegex {
+		regex, err := regexp.Compile(cfg.ExtensionPattern)
+		if err != nil {
+			return nil, err
+		}
+		return func(u string) bool { return regex.MatchString(strings.ToLower(u)) }, nil
+	}
+	profiles := cfg.ActiveProfiles()
+	return func(u string) bool {
+		for _, p := range profiles {
+			if p.matches(u, cfg.QueryExtensions) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// ExtensionRegex returns the [FileExtensions] rules as a single regular
+// expression that has to match the whole URL, as the CDX servers expect. It
+// is empty when an active profile accepts any extension.
+func (s *Settings) ExtensionRegex() string {
+	if s.ExtensionMode == MatchRegex {
+		return ".*(?:" + s.ExtensionPattern + ").*"
+	}
+	extensions := s.profileExtensions()
+	if len(extensions) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(extensions))
+	for i, ext := range extensions {
+		quoted[i] = regexp.QuoteMeta(ext)
+	}
+	exts := strings.Join(quoted, "|")
+	pattern := `^[^?#]*[^/?#]\.(?:` + exts + `)(?:[?#].*)?$`
+	if s.QueryExtensions {
+		pattern += `|^[^#]*[?&][^=&#]*=[^&#]*[^/=&#]\.(?:` + exts + `)(?:[&#].*)?$`
+	}
+	return pattern
+}
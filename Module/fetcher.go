@@ -2,16 +2,17 @@ package module
 
 import (
 	"bufio"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"gopkg.in/ini.v1"
 
     "archseek/loader"
     
@@ -21,6 +22,12 @@ import (
 
 const (
 	WaybackURL = "https://web.archive.org/cdx/search/cdx"
+
+	// WaybackSnapshotURL serves raw archived copies as <timestamp>id_/<url>.
+	WaybackSnapshotURL = "https://web.archive.org/web/"
+
+	// maxCDXLine bounds a single line of a CDX response.
+	maxCDXLine = 1024 * 1024
 )
 
 var (
@@ -34,65 +41,91 @@ var (
 	magenta = color.New(color.FgMagenta)
 )
 
-type WaybackResponse struct {
-	URLs []string
+// WaybackSource lists URLs from the Wayback Machine CDX API.
+type WaybackSource struct {
+    Endpoint string
 }
 
-func FetchWaybackURLs(domain string) []string {
-    loader := loader.New("[INFO] Fetching URLs from Wayback Machine")
-    loader.Start()
-    defer loader.Stop()
-
-    params := url.Values{}
-    params.Add("url", "*."+domain+"/*")
-    params.Add("collapse", "urlkey")
-    params.Add("output", "text")
-    params.Add("fl", "original")
-
-    resp, err := http.Get(WaybackURL + "?" + params.Encode())
-    if err != nil {
-        red.Print("[ERROR] ")
-        fmt.Printf("Failed to fetch URLs from Wayback Machine for %s\n", domain)
-        return nil
-    }
-    defer resp.Body.Close()
+func (s *WaybackSource) Name() string {
+    return "Wayback Machine"
+}
 
-    body, err := ioutil.ReadAll(resp.Body)
+// Stream reads the CDX listing for target line by line and passes each
+// capture to emit as it arrives.
+func (s *WaybackSource) Stream(target Target, emit func(CDXRecord)) (int, error) {
+    pager, err := newCDXPager()
     if err != nil {
-        return nil
+        return 0, err
     }
 
-    urls := strings.Split(string(body), "\n")
-    // Remove empty strings
-    var filteredUrls []string
-    for _, url := range urls {
-        if url != "" {
-            filteredUrls = append(filteredUrls, url)
+    cfg, _ := CurrentSettings()
+    params := url.Values{}
+    params.Add("url", target.queryURL(cfg))
+    params.Add("collapse", cfg.Collapse)
+    params.Add("output", "text")
+    params.Add("fl", cdxFields)
+    addDateRange(params, target, cfg)
+    addServerFilters(params, cfg, waybackFilterFields)
+
+    return pager.stream(cdxQuery{Name: "wayback-" + target.Domain, Endpoint: s.Endpoint, Params: params}, func(line string) {
+        record, err := parseCDXLine(line)
+        if err != nil {
+            return
         }
-    }
+        emit(record)
+    })
+}
 
-    green.Print("[SUCCESS] ")
-    red.Printf("%d ", len(filteredUrls))
-    fmt.Printf("URLs retrieved from Wayback Machine for %s\n", domain)
-    loader.Stop()
+// newRecordFilter builds the file type, MIME type and size matcher from the
+// active settings.
+func newRecordFilter() (func(CDXRecord) bool, error) {
+	// Load file extensions from settings.ini
+	cfg, err := CurrentSettings()
+	if err != nil {
+		return nil, err
+	}
+	matchURL, err := extensionMatcher(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r CDXRecord) bool {
+		if !matchURL(r.URL) {
+			return false
+		}
+		if len(cfg.MimeTypes) > 0 && !hasAnyPrefix(strings.ToLower(r.MimeType), cfg.MimeTypes) {
+			return false
+		}
+		if cfg.MinLength > 0 && r.Length < cfg.MinLength {
+			return false
+		}
+		if cfg.MaxLength > 0 && r.Length > cfg.MaxLength {
+			return false
+		}
+		return true
+	}, nil
+}
 
-    return filteredUrls
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
 }
 
-func FilterURLsByFiletype(urls []string) []string {
-	// Load file extensions from settings.ini
-	cfg, err := ini.Load("settings.ini")
+func FilterURLsByFiletype(records []CDXRecord) []CDXRecord {
+	match, err := newRecordFilter()
 	if err != nil {
 		red.Print("[ERROR] ")
 		fmt.Printf("Failed to load settings: %v\n", err)
 		return nil
 	}
-	FileExtensions = cfg.Section("FileExtensions").Key("Extensions").MustString(`\.(xls|xml|xlsx|json|pdf|sql|doc|docx|pptx|txt|zip|tar\.gz|tgz|bak|7z|rar|log|cache|secret|db|backup|yml|gz|config|csv|yaml|md|md5|exe|dll|bin|ini|bat|sh|tar|deb|rpm|iso|img|apk|msi|dmg|tmp|crt|pem|key|pub|asc)`)
-	regex := regexp.MustCompile(FileExtensions)
-	filtered := make([]string, 0)
+	filtered := make([]CDXRecord, 0)
 
 	// Create progress bar for filtering
-	bar := progressbar.NewOptions(len(urls),
+	bar := progressbar.NewOptions(len(records),
 		progressbar.OptionEnableColorCodes(true),
 		progressbar.OptionShowCount(),
 		progressbar.OptionSetWidth(15),
@@ -106,9 +139,9 @@ func FilterURLsByFiletype(urls []string) []string {
 		}),
 	)
 
-	for _, u := range urls {
-		if regex.MatchString(strings.ToLower(u)) {
-			filtered = append(filtered, u)
+	for _, r := range records {
+		if match(r) {
+			filtered = append(filtered, r)
 		}
 		bar.Add(1)
 	}
@@ -120,32 +153,55 @@ func FilterURLsByFiletype(urls []string) []string {
 	return filtered
 }
 
-func ValidateURLs(urls []string) []string {
-	var validURLs []string
-	var mu sync.Mutex
-
-    // Load settings from ini file
-    cfg, err := ini.Load("settings.ini")
+// ValidateURLs checks records, downloading the valid ones unless disabled,
+// and returns each with its Validation result.
+func ValidateURLs(records []CDXRecord) []CDXRecord {
+    cfg, err := CurrentSettings()
     if err != nil {
         red.Print("[ERROR] ")
         fmt.Printf("Failed to load settings: %v\n", err)
         return nil
     }
 
-    batchSize := cfg.Section("BatchProcessing").Key("BatchSize").MustInt(10)
-    maxThreads := cfg.Section("BatchProcessing").Key("MaxThreads").MustInt(5)
-    timeout := cfg.Section("BatchProcessing").Key("Timeout").MustInt(15)
-
     // Create download manager with configured max threads
-    dm := NewDownloadManager(maxThreads)
-    if err := os.MkdirAll(dm.OutputDir, 0755); err != nil {
+    var dm *DownloadManager
+    if !cfg.NoDownload {
+        dm = NewDownloadManager(cfg.MaxThreads)
+    }
+    return validateURLs(records, dm)
+}
+
+// validateURLs checks every record, most interesting first, and hands the
+// valid ones of each batch to dm. A nil dm only validates. Every record is
+// returned with its Validation result, see ValidRecords.
+func validateURLs(records []CDXRecord, dm *DownloadManager) []CDXRecord {
+	var checked []CDXRecord
+	var mu sync.Mutex
+
+    cfg, err := CurrentSettings()
+    if err != nil {
         red.Print("[ERROR] ")
-        fmt.Printf("Failed to create download directory: %v\n", err)
-        return validURLs
+        fmt.Printf("Failed to load settings: %v\n", err)
+        return nil
+    }
+
+    batchSize := cfg.BatchSize
+    timeout := cfg.Timeout
+    // Most interesting files first, taking turns between hosts on ties
+    records = interleaveHosts(rankRecords(records))
+    soft404.setProbes(cfg.Soft404Probes)
+    hostLimits.setLimits(cfg.MaxPerHost, cfg.HostRate)
+
+    if dm != nil {
+        if err := os.MkdirAll(dm.OutputDir, 0755); err != nil {
+            red.Print("[ERROR] ")
+            fmt.Printf("Failed to create download directory: %v\n", err)
+            return checked
+        }
     }
 
     // Process URLs in batches
-    totalBatches := (len(urls) + batchSize - 1) / batchSize
+    totalBatches := (len(records) + batchSize - 1) / batchSize
 
     // Create progress bar for batch processing
     batchLoader := loader.New("[INFO] Processing batches")
@@ -166,60 +222,40 @@ func ValidateURLs(urls []string) []string {
         }),
     )
 
-    // Create HTTP client with configured timeout
+    // Create HTTP client with configured timeout, per-host limits and
+    // redirect policy
     client := &http.Client{
-        Timeout: time.Duration(timeout) * time.Second,
-        Transport: &http.Transport{
-            MaxIdleConns:        50,
-            MaxIdleConnsPerHost: 50,
-            IdleConnTimeout:     90 * time.Second,
-            DisableCompression: true,
+        CheckRedirect: validationRedirects(cfg, nil),
+        Transport: &hostTransport{
+            base: &http.Transport{
+                MaxIdleConns:        50,
+                MaxIdleConnsPerHost: 50,
+                IdleConnTimeout:     90 * time.Second,
+                DisableCompression: true,
+            },
+            timeout: time.Duration(timeout) * time.Second,
         },
     }
 
     // Process URLs in batches
-    for i := 0; i < len(urls); i += batchSize {
+    for i := 0; i < len(records); i += batchSize {
         end := i + batchSize
-        if end > len(urls) {
-            end = len(urls)
+        if end > len(records) {
+            end = len(records)
         }
-        batch := urls[i:end]
+        batch := records[i:end]
 
         // Validate URLs in current batch
-        batchValidURLs := make([]string, 0)
+        batchValidURLs := make([]CDXRecord, 0)
         var batchWg sync.WaitGroup
-        batchResults := make(chan string, len(batch))
+        batchResults := make(chan CDXRecord, len(batch))
 
-        for _, url := range batch {
+        for _, record := range batch {
             batchWg.Add(1)
-            go func(url string) {
+            go func(record CDXRecord) {
                 defer batchWg.Done()
-                maxRetries := 3
-
-                for attempt := 1; attempt <= maxRetries; attempt++ {
-                    resp, err := client.Head(url)
-                    if err != nil {
-                        if attempt == maxRetries {
-                            red.Print("[ERROR] ")
-                            fmt.Printf("Failed to validate %s after %d attempts: %v\n", url, maxRetries, err)
-                        }
-                        time.Sleep(time.Duration(attempt) * time.Second)
-                        continue
-                    }
-                    resp.Body.Close()
-
-                    if resp.StatusCode == 200 {
-                        batchResults <- url
-                        break
-                    }
-
-                    if attempt == maxRetries {
-                        red.Print("[ERROR] ")
-                        fmt.Printf("URL %s returned status code %d\n", url, resp.StatusCode)
-                    }
-                    break
-                }
-            }(url)
+                batchResults <- checkRecord(client, cfg, record)
+            }(record)
         }
 
         // Wait for batch validation to complete
@@ -228,49 +264,111 @@ func ValidateURLs(urls []string) []string {
             close(batchResults)
         }()
 
-        // Collect valid URLs from batch
-        for url := range batchResults {
-            batchValidURLs = append(batchValidURLs, url)
+        // Collect the results of the batch
+        var batchChecked []CDXRecord
+        for record := range batchResults {
+            batchChecked = append(batchChecked, record)
+            if record.Downloadable() {
+                batchValidURLs = append(batchValidURLs, record)
+            }
         }
+        sortByScore(batchValidURLs)
 
         // Download valid URLs from the batch
-        if len(batchValidURLs) > 0 {
+        if dm != nil && len(batchValidURLs) > 0 {
             if err := dm.Download(batchValidURLs); err != nil {
                 red.Print("[ERROR] ")
                 fmt.Printf("Error during batch download: %v\n", err)
             }
         }
 
-        // Update overall valid URLs list
+        // Update overall results list
         mu.Lock()
-        validURLs = append(validURLs, batchValidURLs...)
+        checked = append(checked, batchChecked...)
         mu.Unlock()
 
         bar.Add(1)
         cyan.Print("[INFO] ")
-        fmt.Printf("Batch %d/%d: Found %d valid URLs\n", (i/batchSize)+1, totalBatches, len(batchValidURLs))
+        fmt.Printf("Batch %d/%d: Found %d valid URLs\n", (i/batchSize)+1, totalBatches, len(ValidRecords(batchChecked)))
     }
 
     green.Print("[SUCCESS] ")
-    red.Printf("%d ", len(validURLs))
+    red.Printf("%d ", len(ValidRecords(checked)))
     fmt.Println("valid URLs processed")
 
-    return validURLs
+    return checked
 }
 
-func SaveToFile(data []string, filename string) error {
+// archiveAvailable reports whether the archived copy of record can still be
+// fetched from the Wayback Machine.
+func archiveAvailable(client *http.Client, record CDXRecord) bool {
+    if record.LastOK == "" {
+        return false
+    }
+    resp, err := client.Head(record.ArchiveURL())
+    if err != nil {
+        return false
+    }
+    resp.Body.Close()
+    return resp.StatusCode == http.StatusOK
+}
+
+// SaveToFile writes the records to filename. A .json file gets every
+// field, a .csv file one row per record and anything else one URL per line.
+func SaveToFile(data []CDXRecord, filename string) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
+	// Candidates the status policy discards are never saved
+	kept := make([]CDXRecord, 0, len(data))
+	for _, r := range data {
+		if r.Validation == nil || r.Valid() {
+			kept = append(kept, r)
+		}
+	}
+	data = kept
+
 	writer := bufio.NewWriter(file)
-	for _, url := range data {
-		_, err := writer.WriteString(url + "\n")
-		if err != nil {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		if data == nil {
+			data = []CDXRecord{}
+		}
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(data); err != nil {
 			return err
 		}
+	case ".csv":
+		w := csv.NewWriter(writer)
+		w.Write([]string{"url", "first_seen", "last_seen", "mimetype", "statuscode", "digest", "length", "found_in", "source", "variants", "score", "mismatch", "live_status", "policy"})
+		for _, r := range data {
+			liveStatus, policy := "", ""
+			if r.Validation != nil {
+				liveStatus, policy = strconv.Itoa(r.Validation.Status), r.Validation.Policy
+			}
+			w.Write([]string{r.URL, r.FirstSeen, r.LastSeen, r.MimeType, r.StatusCode, r.Digest, strconv.FormatInt(r.Length, 10), strings.Join(r.FoundIn, ";"), r.Source, strings.Join(r.Variants, ";"), strconv.Itoa(r.Score), r.Mismatch, liveStatus, policy})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+	default:
+		// Report-only findings are listed as comments, so the file still
+		// works as a download list
+		for _, r := range data {
+			line := r.URL
+			if r.Validation != nil && r.Validation.Policy == PolicyReportOnly {
+				line = fmt.Sprintf("# %d %s", r.Validation.Status, r.URL)
+			}
+			_, err := writer.WriteString(line + "\n")
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	err = writer.Flush()
@@ -283,28 +381,178 @@ func SaveToFile(data []string, filename string) error {
 	return nil
 }
 
-func ProcessDomains(domains []string) {
-    var allURLs []string
+// Summary describes the outcome of a ProcessDomains run. For ProcessImports
+// each input file counts as a domain.
+type Summary struct {
+	Domains         int
+	FailedDomains   int
+	Candidates      int
+	Valid           int
+	Reported        int // of the valid ones, report-only findings
+	FailedDownloads int
+	SaveError       error
+	PerDomain       []DomainSummary
+}
 
-    for _, domain := range domains {
-        fmt.Print("\n")
-        cyan.Print("[INFO] ")
-        fmt.Printf("Processing domain: %s\n", domain)
+// DomainSummary describes the outcome for one domain.
+type DomainSummary struct {
+	Domain     string
+	Matched    int // URLs matching the file filters
+	Duplicates int // of those, variants of URLs already queued
+	Valid      int
+	Reported   int // of the valid ones, report-only findings
+	Err        error
+}
 
-        waybackURLs := FetchWaybackURLs(domain)
-        filteredURLs := FilterURLsByFiletype(waybackURLs)
-        allURLs = append(allURLs, filteredURLs...)
+func ProcessDomains(domains []string) *Summary {
+    targets, err := DomainTargets(domains)
+    if err != nil {
+        red.Print("[ERROR] ")
+        fmt.Println(err)
+        return &Summary{Domains: len(domains), FailedDomains: len(domains)}
     }
+    return ProcessTargets(targets)
+}
 
-    validURLs := ValidateURLs(allURLs)
-    err := SaveToFile(validURLs, "valid_urls.txt")
+// domainResult is a fetched and filtered domain waiting for validation.
+type domainResult struct {
+	index   int
+	target  Target
+	records []CDXRecord
+	err     error
+}
+
+// ProcessTargets fetches up to DomainWorkers targets at a time. Each domain
+// is validated as soon as its URL list is ready, while the others are
+// still being fetched.
+func ProcessTargets(targets []Target) *Summary {
+    summary := &Summary{Domains: len(targets)}
+
+    cfg, err := CurrentSettings()
+    if err != nil {
+        red.Print("[ERROR] ")
+        fmt.Printf("Failed to load settings: %v\n", err)
+        summary.FailedDomains = len(targets)
+        return summary
+    }
+
+    workers := cfg.DomainWorkers
+    if workers < 1 {
+        workers = 1
+    }
+
+    jobs := make(chan int)
+    ready := make(chan domainResult)
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for i := range jobs {
+                fmt.Print("\n")
+                cyan.Print("[INFO] ")
+                fmt.Printf("[%d/%d] Processing domain: %s\n", i+1, len(targets), targets[i].Domain)
+
+                // Keep whatever was matched before a failure, but report the domain
+                records, err := FetchFilteredURLs(targets[i])
+                ready <- domainResult{index: i, target: targets[i], records: records, err: err}
+            }
+        }()
+    }
+    go func() {
+        for i := range targets {
+            jobs <- i
+        }
+        close(jobs)
+        wg.Wait()
+        close(ready)
+    }()
+
+    var dm *DownloadManager
+    if !cfg.NoDownload {
+        dm = NewDownloadManager(cfg.MaxThreads)
+    }
+
+    // Scheme, port and host spellings of the same file are collapsed across
+    // all domains, so a file is only validated for the first domain listing it
+    dedupe := newDeduper(cfg)
+    var checked []CDXRecord
+    done := 0
+    for result := range ready {
+        done++
+        domain := DomainSummary{Domain: result.target.Domain, Matched: len(result.records), Err: result.err}
+        if result.err != nil {
+            summary.FailedDomains++
+        }
+
+        fresh := dedupe.addAll(result.records)
+        domain.Duplicates = len(result.records) - len(fresh)
+        summary.Candidates += len(fresh)
+
+        if len(fresh) > 0 {
+            cyan.Print("[INFO] ")
+            fmt.Printf("[%d/%d] Validating %d URLs from %s\n", done, len(targets), len(fresh), domain.Domain)
+            results := validateURLs(fresh, dm)
+            domain.Valid = len(ValidRecords(results))
+            domain.Reported = domain.Valid - countDownloadable(results)
+            checked = append(checked, results...)
+        }
+
+        printDomainSummary(domain)
+        summary.PerDomain = append(summary.PerDomain, domain)
+    }
+
+    return finishRun(summary, checked, dedupe, dm, cfg)
+}
+
+// finishRun fills in the totals of a run and saves the valid URLs and the
+// validation results of every candidate.
+func finishRun(summary *Summary, checked []CDXRecord, dedupe *deduper, dm *DownloadManager, cfg *Settings) *Summary {
+    printOutcomes(checked)
+    if cfg.ResultsFile != "" {
+        if err := SaveResults(checked, cfg.ResultsFile); err != nil {
+            red.Print("[ERROR] ")
+            fmt.Printf("Failed to save validation results: %v\n", err)
+        }
+    }
+    validURLs := ValidRecords(checked)
+
+    // Pick up variants that were merged after their file was validated
+    for i := range validURLs {
+        dedupe.refresh(&validURLs[i])
+    }
+    sortByScore(validURLs)
+
+    summary.Valid = len(validURLs)
+    summary.Reported = summary.Valid - countDownloadable(validURLs)
+    if dm != nil {
+        summary.FailedDownloads = len(dm.FailedURLs())
+    }
+
+    err := SaveToFile(validURLs, cfg.OutputFile)
     if err != nil {
         red.Print("[ERROR] ")
         fmt.Printf("Failed to save URLs: %v\n", err)
-        return
+        summary.SaveError = err
+        return summary
     }
 
     magenta.Print("[RESULT] ")
     fmt.Print("Total valid URLs: ")
     red.Printf("%d\n", len(validURLs))
-}
\ No newline at end of file
+    return summary
+}
+
+func printDomainSummary(d DomainSummary) {
+    magenta.Print("[RESULT] ")
+    fmt.Printf("%s: %d matching URLs, %d duplicates, ", d.Domain, d.Matched, d.Duplicates)
+    red.Printf("%d ", d.Valid)
+    fmt.Print("valid")
+    if d.Reported > 0 {
+        fmt.Printf(" (%d report-only)", d.Reported)
+    }
+    if d.Err != nil {
+        red.Print(" (fetch incomplete)")
+    }
+    fmt.Println()
+}
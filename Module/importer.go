@@ -0,0 +1,248 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Import formats. ImportAuto picks one from the first character of the file.
+const (
+	ImportAuto  = "auto"
+	ImportText  = "text"  // one URL per line (gau, waybackurls)
+	ImportJSONL = "jsonl" // one JSON object per line, or a JSON array
+	ImportBurp  = "burp"  // Burp Suite "Save items" XML export
+)
+
+// ImportURLs loads the URL list in path, reading stdin when path is "-".
+// JSON input may be an earlier Archseek result, in which case the capture
+// metadata is kept.
+func ImportURLs(path, format string) ([]CDXRecord, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "" || format == ImportAuto {
+		format = detectImportFormat(data)
+	}
+
+	var records []CDXRecord
+	switch format {
+	case ImportText:
+		records, err = importText(data)
+	case ImportJSONL:
+		records, err = importJSON(data)
+	case ImportBurp:
+		records, err = importBurp(data)
+	default:
+		return nil, fmt.Errorf("unknown import format %q, use text, jsonl or burp", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// Drop anything that isn't an absolute http(s) URL
+	source := "import:" + filepath.Base(path)
+	kept := records[:0]
+	for _, r := range records {
+		u, err := url.Parse(r.URL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		if len(r.FoundIn) == 0 {
+			r.FoundIn = []string{source}
+		}
+		kept = append(kept, r)
+	}
+	if skipped := len(records) - len(kept); skipped > 0 {
+		red.Print("[WARNING] ")
+		fmt.Printf("%d entries in %s are not http(s) URLs and were skipped\n", skipped, path)
+	}
+	return kept, nil
+}
+
+func detectImportFormat(data []byte) string {
+	text := bytes.TrimSpace(data)
+	if len(text) == 0 {
+		return ImportText
+	}
+	switch text[0] {
+	case '[', '{':
+		return ImportJSONL
+	case '<':
+		return ImportBurp
+	}
+	return ImportText
+}
+
+func importText(data []byte) ([]CDXRecord, error) {
+	var records []CDXRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxCDXLine)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		records = append(records, CDXRecord{URL: line})
+	}
+	return records, scanner.Err()
+}
+
+// importJSON reads a JSON array or JSON lines. Objects use the CDXRecord
+// field names, so gau's {"url": ...} lines work as well.
+func importJSON(data []byte) ([]CDXRecord, error) {
+	if text := bytes.TrimSpace(data); len(text) > 0 && text[0] == '[' {
+		var records []CDXRecord
+		if err := json.Unmarshal(text, &records); err != nil {
+			return nil, err
+		}
+		return records, nil
+	}
+
+	var records []CDXRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxCDXLine)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var r CDXRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		records = append(records, r)
+	}
+	return records, scanner.Err()
+}
+
+// burpItem is one <item> of a Burp XML export.
+type burpItem struct {
+	URL            string `xml:"url"`
+	Status         string `xml:"status"`
+	ResponseLength string `xml:"responselength"`
+	MimeType       string `xml:"mimetype"`
+}
+
+func importBurp(data []byte) ([]CDXRecord, error) {
+	var records []CDXRecord
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	// Burp exports declare their DTD inline, which the decoder can't read
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "item" {
+			continue
+		}
+		var item burpItem
+		if err := decoder.DecodeElement(&item, &start); err != nil {
+			return nil, err
+		}
+		length, _ := strconv.ParseInt(item.ResponseLength, 10, 64)
+		records = append(records, CDXRecord{
+			URL:        strings.TrimSpace(item.URL),
+			MimeType:   item.MimeType,
+			StatusCode: item.Status,
+			Length:     length,
+		})
+	}
+	return records, nil
+}
+
+// ProcessImports validates, and unless disabled downloads, the URLs listed
+// in paths without querying any archive. The lists go through the same
+// exclusions, file filters and deduplication as fetched URLs.
+func ProcessImports(paths []string, format string) *Summary {
+	summary := &Summary{Domains: len(paths)}
+
+	cfg, err := CurrentSettings()
+	if err != nil {
+		red.Print("[ERROR] ")
+		fmt.Printf("Failed to load settings: %v\n", err)
+		summary.FailedDomains = len(paths)
+		return summary
+	}
+
+	var dm *DownloadManager
+	if !cfg.NoDownload {
+		dm = NewDownloadManager(cfg.MaxThreads)
+	}
+
+	dedupe := newDeduper(cfg)
+	var checked []CDXRecord
+	for i, path := range paths {
+		fmt.Print("\n")
+		cyan.Print("[INFO] ")
+		fmt.Printf("[%d/%d] Importing URLs from %s\n", i+1, len(paths), path)
+
+		file := DomainSummary{Domain: path}
+		records, err := ImportURLs(path, format)
+		if err != nil {
+			red.Print("[ERROR] ")
+			fmt.Printf("Failed to import %s: %v\n", path, err)
+			file.Err = err
+			summary.FailedDomains++
+			summary.PerDomain = append(summary.PerDomain, file)
+			continue
+		}
+		cyan.Print("[INFO] ")
+		red.Printf("%d ", len(records))
+		fmt.Printf("URLs read from %s\n", path)
+
+		if len(cfg.Exclude) > 0 {
+			kept := records[:0]
+			for _, r := range records {
+				if !Excluded(recordHost(r), cfg.Exclude) {
+					kept = append(kept, r)
+				}
+			}
+			records = kept
+		}
+		records = FilterURLsByFiletype(records)
+		file.Matched = len(records)
+
+		fresh := dedupe.addAll(records)
+		file.Duplicates = len(records) - len(fresh)
+		summary.Candidates += len(fresh)
+
+		if len(fresh) > 0 {
+			cyan.Print("[INFO] ")
+			fmt.Printf("[%d/%d] Validating %d URLs from %s\n", i+1, len(paths), len(fresh), path)
+			results := validateURLs(fresh, dm)
+			file.Valid = len(ValidRecords(results))
+			file.Reported = file.Valid - countDownloadable(results)
+			checked = append(checked, results...)
+		}
+
+		printDomainSummary(file)
+		summary.PerDomain = append(summary.PerDomain, file)
+	}
+
+	return finishRun(summary, checked, dedupe, dm, cfg)
+}
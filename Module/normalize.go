@@ -0,0 +1,149 @@
+package module
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Query parameter handling when normalizing URLs.
+const (
+	QueryKeep  = "keep"
+	QuerySort  = "sort"
+	QueryStrip = "strip"
+)
+
+// NormalizeURL returns the key under which variants of the same file
+// collapse, following the [Normalize] rules. The host is always lowercased
+// and the fragment dropped.
+func NormalizeURL(raw string, cfg *Settings) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		return raw
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+
+	if cfg.StripTrailingDot {
+		host = strings.TrimSuffix(host, ".")
+	}
+	if cfg.StripWWW {
+		host = strings.TrimPrefix(host, "www.")
+	}
+	if cfg.CollapseDefaultPorts && (port == "80" && scheme == "http" || port == "443" && scheme == "https") {
+		port = ""
+	}
+	if cfg.IgnoreScheme {
+		scheme = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+
+	query := u.RawQuery
+	switch cfg.QueryParams {
+	case QueryStrip:
+		query = ""
+	case QuerySort:
+		params := strings.Split(query, "&")
+		sort.Strings(params)
+		query = strings.Join(params, "&")
+	}
+
+	key := scheme + "://" + host + u.EscapedPath()
+	if query != "" {
+		key += "?" + query
+	}
+	return key
+}
+
+// deduper collapses URL variants as records arrive. Records whose URLs
+// normalize to the same key are merged; the kept record lists the URLs
+// merged into it as Variants and prefers an https variant for validation.
+type deduper struct {
+	cfg     *Settings
+	index   map[string]int
+	records []CDXRecord
+}
+
+func newDeduper(cfg *Settings) *deduper {
+	return &deduper{cfg: cfg, index: make(map[string]int)}
+}
+
+// addAll stores records and returns the merged records that are new, so
+// variants within the list, and the https spelling, are folded in before
+// the records are validated.
+func (d *deduper) addAll(records []CDXRecord) []CDXRecord {
+	var added []int
+	for _, r := range records {
+		if i, isNew := d.add(r); isNew {
+			added = append(added, i)
+		}
+	}
+	fresh := make([]CDXRecord, len(added))
+	for j, i := range added {
+		fresh[j] = d.records[i]
+	}
+	return fresh
+}
+
+// add stores r and returns the index of the record it was merged into, and
+// whether r was new rather than a variant of a record already seen.
+func (d *deduper) add(r CDXRecord) (int, bool) {
+	key := NormalizeURL(r.URL, d.cfg)
+	i, ok := d.index[key]
+	if !ok {
+		d.index[key] = len(d.records)
+		d.records = append(d.records, r)
+		return len(d.records) - 1, true
+	}
+
+	kept := &d.records[i]
+	if kept.URL == r.URL {
+		kept.merge(r)
+		return i, false
+	}
+	variant := r.URL
+	if strings.HasPrefix(r.URL, "https://") && !strings.HasPrefix(kept.URL, "https://") {
+		variant, kept.URL = kept.URL, r.URL
+	}
+	kept.merge(r)
+	for _, v := range append(r.Variants, variant) {
+		if v != kept.URL && !containsString(kept.Variants, v) {
+			kept.Variants = append(kept.Variants, v)
+		}
+	}
+	return i, false
+}
+
+// refresh copies into r what variants merged after r was validated add to
+// its captures.
+func (d *deduper) refresh(r *CDXRecord) {
+	i, ok := d.index[NormalizeURL(r.URL, d.cfg)]
+	if !ok {
+		return
+	}
+	kept := d.records[i]
+	r.FirstSeen, r.LastSeen, r.LastOK = kept.FirstSeen, kept.LastSeen, kept.LastOK
+	r.MimeType, r.StatusCode, r.Digest, r.Length = kept.MimeType, kept.StatusCode, kept.Digest, kept.Length
+	r.FoundIn = kept.FoundIn
+	r.Variants = d.variants(r.URL)
+}
+
+// variants returns every spelling merged with u, other than u itself.
+func (d *deduper) variants(u string) []string {
+	i, ok := d.index[NormalizeURL(u, d.cfg)]
+	if !ok {
+		return nil
+	}
+
+	var list []string
+	for _, v := range append([]string{d.records[i].URL}, d.records[i].Variants...) {
+		if v != u {
+			list = append(list, v)
+		}
+	}
+	return list
+}
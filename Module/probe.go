@@ -0,0 +1,90 @@
+package module
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// hostMethods remembers the hosts that refuse HEAD but serve GET, so their
+// later URLs are validated with GET straight away.
+type hostMethods struct {
+	mu  sync.Mutex
+	get map[string]bool
+}
+
+var validationMethods = &hostMethods{get: make(map[string]bool)}
+
+func (m *hostMethods) useGet(host string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.get[host]
+}
+
+// rememberGet records that host needs GET and reports whether it was new.
+func (m *hostMethods) rememberGet(host string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.get[host] {
+		return false
+	}
+	m.get[host] = true
+	return true
+}
+
+// headRefused reports whether a HEAD status may only mean the server
+// doesn't allow HEAD.
+func headRefused(status int) bool {
+	return status == http.StatusMethodNotAllowed || status == http.StatusForbidden || status == http.StatusNotImplemented
+}
+
+// probeURL checks that rawURL can be fetched. It sends HEAD, and falls back
+// to a GET of the first byte when HEAD is refused; a host where that works
+// is validated with GET from then on. The caller closes the body.
+func probeURL(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if validationMethods.useGet(u.Host) {
+		return rangedGet(ctx, client, rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil || !headRefused(resp.StatusCode) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	getResp, err := rangedGet(ctx, client, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if probeOK(getResp.StatusCode) && validationMethods.rememberGet(u.Host) {
+		cyan.Print("[INFO] ")
+		fmt.Printf("%s refuses HEAD (%d), validating its URLs with GET\n", u.Host, resp.StatusCode)
+	}
+	return getResp, nil
+}
+
+// rangedGet asks for the first byte of rawURL only. Servers ignoring the
+// range send the whole file, which the caller doesn't read.
+func rangedGet(ctx context.Context, client *http.Client, rawURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	return client.Do(req)
+}
+
+// probeOK reports whether a probe status means the file is there.
+func probeOK(status int) bool {
+	return status == http.StatusOK || status == http.StatusPartialContent
+}
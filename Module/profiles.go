@@ -0,0 +1,201 @@
+package module
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"gopkg.in/ini.v1"
+)
+
+// profileSection prefixes the settings.ini sections defining filter
+// profiles, e.g. [Profile.documents].
+const profileSection = "Profile."
+
+// FilterProfile is a named set of file rules. A URL matches a profile when
+// its extension is included (or Include is empty), not excluded, and its
+// path contains one of the Keywords if any are set. Combined profiles match
+// a URL if any one of them does.
+type FilterProfile struct {
+	Name     string
+	Include  []string
+	Exclude  []string
+	Keywords []string
+}
+
+// loadProfiles reads every [Profile.<name>] section.
+func loadProfiles(cfg *ini.File) ([]FilterProfile, error) {
+	var profiles []FilterProfile
+	for _, section := range cfg.Sections() {
+		name, ok := strings.CutPrefix(section.Name(), profileSection)
+		if !ok {
+			continue
+		}
+		p := FilterProfile{
+			Name:     strings.ToLower(name),
+			Include:  parseExtensions(section.Key("Include").String()),
+			Exclude:  parseExtensions(section.Key("Exclude").String()),
+			Keywords: splitList(strings.ToLower(section.Key("Keywords").String())),
+		}
+		if len(p.Include) == 0 && len(p.Keywords) == 0 {
+			return nil, fmt.Errorf("[%s] needs Include or Keywords", section.Name())
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, nil
+}
+
+// SelectProfiles makes the named profiles the active file rules. An empty
+// list goes back to the plain [FileExtensions] list.
+func (s *Settings) SelectProfiles(names []string) error {
+	var selected []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := s.profile(name); !ok {
+			return fmt.Errorf("unknown filter profile %q, defined: %s", name, strings.Join(s.ProfileNames(), ", "))
+		}
+		selected = append(selected, name)
+	}
+	if len(selected) > 0 && s.ExtensionMode == MatchRegex {
+		return fmt.Errorf("filter profiles need Mode = extension under [FileExtensions]")
+	}
+	s.SelectedProfiles = selected
+	return nil
+}
+
+// ProfileNames lists the defined profiles in settings.ini order.
+func (s *Settings) ProfileNames() []string {
+	names := make([]string, len(s.Profiles))
+	for i, p := range s.Profiles {
+		names[i] = p.Name
+	}
+	return names
+}
+
+func (s *Settings) profile(name string) (FilterProfile, bool) {
+	for _, p := range s.Profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return FilterProfile{}, false
+}
+
+// ActiveProfiles returns the selected profiles, or the Extensions list as a
+// single "default" profile when none are selected.
+func (s *Settings) ActiveProfiles() []FilterProfile {
+	if len(s.SelectedProfiles) == 0 {
+		return []FilterProfile{{Name: "default", Include: s.Extensions}}
+	}
+	var active []FilterProfile
+	for _, name := range s.SelectedProfiles {
+		if p, ok := s.profile(name); ok {
+			active = append(active, p)
+		}
+	}
+	return active
+}
+
+// matches reports whether the URL raw falls under p.
+func (p FilterProfile) matches(raw string, queryValues bool) bool {
+	if len(p.Include) > 0 && URLExtension(raw, p.Include, queryValues) == "" {
+		return false
+	}
+	if len(p.Exclude) > 0 && URLExtension(raw, p.Exclude, false) != "" {
+		return false
+	}
+	if len(p.Keywords) == 0 {
+		return true
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	words := pathWords(u.Path)
+	for _, keyword := range p.Keywords {
+		if hasKeyword(words, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathWords splits a path into its lowercased words: runs of letters and
+// runs of digits, so db_backup2019.sql gives db, backup, 2019 and sql.
+func pathWords(p string) []string {
+	p = strings.ToLower(p)
+	var words []string
+	start, kind := -1, 0
+	for i, r := range p {
+		k := 0
+		switch {
+		case unicode.IsLetter(r):
+			k = 1
+		case unicode.IsDigit(r):
+			k = 2
+		}
+		if k != kind && start >= 0 {
+			words = append(words, p[start:i])
+			start = -1
+		}
+		if k != 0 && start < 0 {
+			start = i
+		}
+		kind = k
+	}
+	if start >= 0 {
+		words = append(words, p[start:])
+	}
+	return words
+}
+
+// hasKeyword reports whether keyword appears in words as whole words, so
+// old doesn't match golden. A plural of its last word counts too.
+func hasKeyword(words []string, keyword string) bool {
+	want := pathWords(keyword)
+	if len(want) == 0 {
+		return false
+	}
+	for i := 0; i+len(want) <= len(words); i++ {
+		matched := true
+		for j, w := range want {
+			word := words[i+j]
+			last := j == len(want)-1
+			if word != w && !(last && (word == w+"s" || word == w+"es")) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// profileExtensions returns every extension the active profiles include, or
+// nil if one of them takes any extension.
+func (s *Settings) profileExtensions() []string {
+	var exts []string
+	for _, p := range s.ActiveProfiles() {
+		if len(p.Include) == 0 {
+			return nil
+		}
+		for _, ext := range p.Include {
+			if !containsString(exts, ext) {
+				exts = append(exts, ext)
+			}
+		}
+	}
+	return exts
+}
+
+// ProfileSelected reports whether the named profile is active.
+func (s *Settings) ProfileSelected(name string) bool {
+	return containsString(s.SelectedProfiles, name)
+}
@@ -0,0 +1,189 @@
+package module
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// cdxFields is the field list requested from the CDX API. The original URL
+// goes last so a URL containing spaces still parses.
+const cdxFields = "timestamp,mimetype,statuscode,digest,length,original"
+
+// CDXRecord is one archived URL together with what the CDX API knows about
+// its captures.
+type CDXRecord struct {
+	URL        string `json:"url"`
+	FirstSeen  string `json:"first_seen"`
+	LastSeen   string `json:"last_seen"`
+	MimeType   string `json:"mimetype"`
+	StatusCode string `json:"statuscode"`
+	Digest     string `json:"digest"`
+	Length     int64  `json:"length"`
+
+	// LastOK is the newest capture with a 2xx status, the one the archive
+	// fallback downloads. Later captures are often the 404 or redirect
+	// that made the file disappear.
+	LastOK string `json:"last_ok,omitempty"`
+
+	// FoundIn lists the URL sources that returned the URL.
+	FoundIn []string `json:"found_in,omitempty"`
+	// Variants lists other spellings of the URL (scheme, port, www. ...)
+	// that were merged into this record.
+	Variants []string `json:"variants,omitempty"`
+
+	// Source tells whether the file is fetched from the live host or from
+	// the archived snapshot. It is set during validation.
+	Source string `json:"source,omitempty"`
+
+	// Score rates how interesting the file looks, see ScoreURL.
+	Score int `json:"score"`
+	// Mismatch says why the content didn't look like the file type, when
+	// signature mismatches are only flagged.
+	Mismatch string `json:"mismatch,omitempty"`
+
+	// Target is the scanned domain the URL was found for, which redirects
+	// must stay in. It is nil for imported URLs.
+	Target *Target `json:"-"`
+
+	// Validation is set once the URL has been checked.
+	Validation *ValidationResult `json:"validation,omitempty"`
+}
+
+// Values for CDXRecord.Source.
+const (
+	SourceLive    = "live"
+	SourceArchive = "archive"
+)
+
+// snapshotBase returns the configured Wayback snapshot endpoint.
+func snapshotBase() string {
+	if cfg, err := CurrentSettings(); err == nil && cfg.WaybackSnapshot != "" {
+		return cfg.WaybackSnapshot
+	}
+	return WaybackSnapshotURL
+}
+
+// ArchiveURL returns the raw archived copy of the latest successful
+// capture.
+func (r CDXRecord) ArchiveURL() string {
+	return snapshotBase() + r.LastOK + "id_/" + r.URL
+}
+
+// FetchURL returns the URL the file is downloaded from.
+func (r CDXRecord) FetchURL() string {
+	if r.Source == SourceArchive {
+		return r.ArchiveURL()
+	}
+	return r.URL
+}
+
+// redirectScope returns the target redirects of FetchURL are judged
+// against, nil to judge them against the URL's own host. Archived copies
+// are served by the archive, whatever the target.
+func (r CDXRecord) redirectScope() *Target {
+	if r.Source == SourceArchive {
+		return nil
+	}
+	return r.Target
+}
+
+// recordFromFetchURL rebuilds a record from a URL returned by FetchURL.
+func recordFromFetchURL(u string) CDXRecord {
+	if rest := strings.TrimPrefix(u, snapshotBase()); rest != u {
+		if i := strings.Index(rest, "id_/"); i > 0 {
+			return CDXRecord{URL: rest[i+4:], LastSeen: rest[:i], LastOK: rest[:i], Source: SourceArchive}
+		}
+	}
+	return CDXRecord{URL: u, Source: SourceLive}
+}
+
+// recordHost returns the host name of the record's URL without port.
+func recordHost(r CDXRecord) string {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// parseCDXLine parses a line of text output requested with cdxFields.
+func parseCDXLine(line string) (CDXRecord, error) {
+	fields := strings.SplitN(line, " ", 6)
+	if len(fields) != 6 {
+		return CDXRecord{}, fmt.Errorf("unexpected CDX line %q", line)
+	}
+
+	length, _ := strconv.ParseInt(fields[4], 10, 64)
+	return CDXRecord{
+		URL:        fields[5],
+		FirstSeen:  fields[0],
+		LastSeen:   fields[0],
+		LastOK:     okTimestamp(fields[0], fields[2]),
+		MimeType:   fields[1],
+		StatusCode: fields[2],
+		Digest:     fields[3],
+		Length:     length,
+	}, nil
+}
+
+// okTimestamp returns the timestamp of a capture if its status is 2xx.
+func okTimestamp(timestamp, status string) string {
+	if strings.HasPrefix(status, "2") {
+		return timestamp
+	}
+	return ""
+}
+
+// merge folds a later capture of the same URL into r. The capture details
+// are taken from whichever capture is newest.
+func (r *CDXRecord) merge(other CDXRecord) {
+	for _, name := range other.FoundIn {
+		if !containsString(r.FoundIn, name) {
+			r.FoundIn = append(r.FoundIn, name)
+		}
+	}
+	if other.FirstSeen != "" && (r.FirstSeen == "" || other.FirstSeen < r.FirstSeen) {
+		r.FirstSeen = other.FirstSeen
+	}
+	if other.LastOK > r.LastOK {
+		r.LastOK = other.LastOK
+	}
+	if other.LastSeen > r.LastSeen {
+		r.LastSeen = other.LastSeen
+		r.MimeType = other.MimeType
+		r.StatusCode = other.StatusCode
+		r.Digest = other.Digest
+		r.Length = other.Length
+	}
+}
+
+// recordSet collects records, merging captures of the same URL while
+// keeping the order in which URLs were first seen.
+type recordSet struct {
+	index   map[string]int
+	records []CDXRecord
+}
+
+func newRecordSet() *recordSet {
+	return &recordSet{index: make(map[string]int)}
+}
+
+func (s *recordSet) add(r CDXRecord) {
+	if i, ok := s.index[r.URL]; ok {
+		s.records[i].merge(r)
+		return
+	}
+	s.index[r.URL] = len(s.records)
+	s.records = append(s.records, r)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package module
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Redirect check modes for [Validation] RedirectCheck.
+const (
+	RedirectAllow  = "allow"  // follow every redirect up to MaxRedirects
+	RedirectFlag   = "flag"   // follow them, but mark the result
+	RedirectRefuse = "refuse" // stop at the redirect and reject the URL
+)
+
+// DefaultLoginPaths are path segments and host labels of login and single
+// sign-on pages.
+const DefaultLoginPaths = "login, logon, signin, sign-in, wp-login, sso, saml, saml2, oauth, oauth2, openid, cas, adfs, idp, auth, authorize, authenticate"
+
+// RedirectError stops a redirect the policy doesn't follow.
+type RedirectError struct {
+	// Chain lists every URL requested, the refused target last.
+	Chain  []string
+	Reason string
+	// OffScope is set when the target left the scanned domain, which often
+	// means the domain has moved or been parked.
+	OffScope bool
+}
+
+func (e *RedirectError) Error() string {
+	return "redirect " + e.Reason
+}
+
+// redirectPolicy returns the CheckRedirect function of the validation and
+// download clients. It stops after cfg.MaxRedirects hops and, in refuse
+// mode, at the first hop leaving scope or landing on a login page. Scope is
+// the scanned target, or the first URL's host when scope is nil.
+func redirectPolicy(cfg *Settings, scope *Target) func(req *http.Request, via []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > cfg.MaxRedirects {
+			return &RedirectError{
+				Chain:  redirectChain(req, via),
+				Reason: fmt.Sprintf("limit: more than %d redirects", cfg.MaxRedirects),
+			}
+		}
+		if cfg.RedirectCheck != RedirectRefuse {
+			return nil
+		}
+		if reason, offScope := redirectProblem(cfg, scope, via[0].URL, req.URL); reason != "" {
+			return &RedirectError{Chain: redirectChain(req, via), Reason: reason, OffScope: offScope}
+		}
+		return nil
+	}
+}
+
+// validationRedirects is redirectPolicy for validation, which also stops at
+// a first redirect whose status has its own [StatusPolicy] rule, so 3xx
+// rules get to classify it.
+func validationRedirects(cfg *Settings, scope *Target) func(req *http.Request, via []*http.Request) error {
+	follow := redirectPolicy(cfg, scope)
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) == 1 && req.Response != nil && cfg.StatusPolicy.stopsAt(req.Response.StatusCode) {
+			return http.ErrUseLastResponse
+		}
+		return follow(req, via)
+	}
+}
+
+func redirectChain(req *http.Request, via []*http.Request) []string {
+	chain := make([]string, 0, len(via)+1)
+	for _, r := range via {
+		chain = append(chain, r.URL.String())
+	}
+	return append(chain, req.URL.String())
+}
+
+// redirectProblem returns why a redirect from origin to target shouldn't
+// be trusted, or "" if it's fine. offScope tells a host change apart from
+// a login page.
+func redirectProblem(cfg *Settings, scope *Target, origin, target *url.URL) (reason string, offScope bool) {
+	if !Excluded(target.Hostname(), cfg.RedirectHosts) {
+		if scope != nil && !scope.inScope(target.Hostname(), cfg) {
+			return fmt.Sprintf("leaves the scope of %s for %s", scope.Domain, target.Hostname()), true
+		}
+		if scope == nil && !sameScope(origin.Hostname(), target.Hostname()) {
+			return fmt.Sprintf("leaves %s for %s", origin.Hostname(), target.Hostname()), true
+		}
+	}
+	// A file that itself sits below /auth/ may be redirected within it
+	if loginPage(target, cfg.LoginPaths) && !loginPage(origin, cfg.LoginPaths) {
+		return "to login page " + target.Host + target.EscapedPath(), false
+	}
+	return "", false
+}
+
+// chainProblem checks every hop of a followed redirect chain.
+func chainProblem(cfg *Settings, scope *Target, chain []string) string {
+	if len(chain) < 2 {
+		return ""
+	}
+	origin, err := url.Parse(chain[0])
+	if err != nil {
+		return ""
+	}
+	for _, hop := range chain[1:] {
+		target, err := url.Parse(hop)
+		if err != nil {
+			continue
+		}
+		if reason, _ := redirectProblem(cfg, scope, origin, target); reason != "" {
+			return "redirect " + reason
+		}
+	}
+	return ""
+}
+
+// sameScope reports whether target is origin, a subdomain of it or one of
+// its parent domains, ignoring a leading www. It stands in for the scope of
+// URLs without a target.
+func sameScope(origin, target string) bool {
+	origin = scopeHost(origin)
+	target = scopeHost(target)
+	switch {
+	case origin == target:
+		return true
+	case strings.HasSuffix(target, "."+origin):
+		return true
+	case strings.Contains(target, ".") && strings.HasSuffix(origin, "."+target):
+		return true
+	}
+	return false
+}
+
+func scopeHost(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return strings.TrimPrefix(host, "www.")
+}
+
+// loginPage reports whether u looks like a login page: a path segment,
+// without its extension, or the first host label is one of loginPaths.
+func loginPage(u *url.URL, loginPaths []string) bool {
+	if label, _, _ := strings.Cut(strings.ToLower(u.Hostname()), "."); containsString(loginPaths, label) {
+		return true
+	}
+	for _, segment := range strings.Split(strings.ToLower(u.Path), "/") {
+		segment = strings.TrimSuffix(segment, path.Ext(segment))
+		if segment != "" && containsString(loginPaths, segment) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,199 @@
+package module
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// hostSlots limits the requests to one host.
+type hostSlots struct {
+	// inFlight holds a token per request in flight, nil when unlimited.
+	inFlight chan struct{}
+	mu       sync.Mutex
+	// next is the earliest start of the next request.
+	next time.Time
+}
+
+// hostScheduler caps the requests in flight and the request rate per host,
+// so a batch full of one host's URLs doesn't hit it all at once. It is
+// shared by validation and downloads, across every domain of the run.
+type hostScheduler struct {
+	mu       sync.Mutex
+	hosts    map[string]*hostSlots
+	maxHost  int
+	interval time.Duration
+}
+
+var hostLimits = &hostScheduler{hosts: make(map[string]*hostSlots)}
+
+// setLimits sets the requests in flight per host and the requests per
+// second per host; zero disables either limit.
+func (s *hostScheduler) setLimits(maxHost int, rate float64) {
+	interval := time.Duration(0)
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxHost != s.maxHost || interval != s.interval {
+		s.maxHost, s.interval = maxHost, interval
+		s.hosts = make(map[string]*hostSlots)
+	}
+}
+
+func (s *hostScheduler) slots(host string) (*hostSlots, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hosts[host]
+	if !ok {
+		h = &hostSlots{}
+		if s.maxHost > 0 {
+			h.inFlight = make(chan struct{}, s.maxHost)
+		}
+		s.hosts[host] = h
+	}
+	return h, s.interval
+}
+
+// acquire waits until host may get another request and returns the function
+// that ends it.
+func (s *hostScheduler) acquire(ctx context.Context, host string) (func(), error) {
+	h, interval := s.slots(host)
+	release := func() {}
+	if h.inFlight != nil {
+		select {
+		case h.inFlight <- struct{}{}:
+			release = func() { <-h.inFlight }
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if interval <= 0 {
+		return release, nil
+	}
+
+	h.mu.Lock()
+	start := h.next
+	if now := time.Now(); start.Before(now) {
+		start = now
+	}
+	h.next = start.Add(interval)
+	h.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// hostTransport sends every request through hostLimits. The timeout starts
+// once it is the request's turn, so time spent queued behind other requests
+// to the same host doesn't count, and covers reading the body. The request
+// holds its host slot until the body is closed.
+type hostTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	queued := time.Now()
+	release, err := hostLimits.acquire(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	if wait, ok := req.Context().Value(queueWaitKey{}).(*time.Duration); ok {
+		*wait += time.Since(queued)
+	}
+
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+	}
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		release()
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, done: func() {
+		cancel()
+		release()
+	}}
+	return resp, nil
+}
+
+type queueWaitKey struct{}
+
+// withQueueWait returns a context whose requests add the time they spend
+// waiting for their host to wait. The requests must not run concurrently.
+func withQueueWait(ctx context.Context, wait *time.Duration) context.Context {
+	return context.WithValue(ctx, queueWaitKey{}, wait)
+}
+
+// releasingBody ends its request when closed.
+type releasingBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// interleaveHosts takes turns between hosts among records of the same
+// score, keeping the order by score and the order of each host's records,
+// so no host gets a long run of requests while the others wait. records
+// must be sorted by score.
+func interleaveHosts(records []CDXRecord) []CDXRecord {
+	interleaved := make([]CDXRecord, 0, len(records))
+	for start := 0; start < len(records); {
+		end := start + 1
+		for end < len(records) && records[end].Score == records[start].Score {
+			end++
+		}
+		interleaved = append(interleaved, roundRobin(records[start:end])...)
+		start = end
+	}
+	return interleaved
+}
+
+// roundRobin reorders records round-robin across their hosts.
+func roundRobin(records []CDXRecord) []CDXRecord {
+	var hosts []string
+	queues := make(map[string][]CDXRecord)
+	for _, r := range records {
+		host := r.FetchURL()
+		if u, err := url.Parse(host); err == nil {
+			host = u.Host
+		}
+		if _, ok := queues[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		queues[host] = append(queues[host], r)
+	}
+
+	interleaved := make([]CDXRecord, 0, len(records))
+	for len(interleaved) < len(records) {
+		for _, host := range hosts {
+			if queue := queues[host]; len(queue) > 0 {
+				interleaved = append(interleaved, queue[0])
+				queues[host] = queue[1:]
+			}
+		}
+	}
+	return interleaved
+}
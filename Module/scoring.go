@@ -0,0 +1,157 @@
+package module
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// PatternWeight adds Weight to the score of files whose name matches Pattern.
+type PatternWeight struct {
+	Pattern *regexp.Regexp
+	Weight  int
+}
+
+// Built-in weights, used for any [Scoring.*] section missing from
+// settings.ini.
+var (
+	defaultExtensionWeights = map[string]int{
+		"sql": 10, "dump": 9, "bak": 9, "backup": 9, "env": 9,
+		"db": 8, "sqlite": 8, "pem": 8, "key": 8, "p12": 8, "pfx": 8,
+		"config": 7, "htpasswd": 7,
+		"7z": 6, "rar": 6, "zip": 6, "tar.gz": 6, "tgz": 6, "conf": 6, "old": 6,
+		"tar": 5, "gz": 5, "ini": 5, "yml": 5, "yaml": 5, "cfg": 5,
+		"log": 4, "crt": 4,
+		"csv": 3, "xls": 3, "xlsx": 3, "secret": 3,
+		"json": 2, "xml": 2, "txt": 2,
+	}
+	defaultKeywordWeights = map[string]int{
+		"backup": 5, "dump": 5, "secret": 6, "private": 4, "config": 4, "old": 2,
+		"password": 6, "credential": 6, "internal": 3, "admin": 2,
+	}
+	defaultPatternWeights = map[string]int{
+		// Dated copies like db_2019-03-01.sql or backup20190301.zip
+		`(19|20)\d{2}[-_.]?(0[1-9]|1[0-2])[-_.]?[0-3]\d`: 3,
+		`(19|20)\d{2}`: 1,
+		// Whole-site or database copies
+		`^(db|database|site|www|full|prod|production)[-_.]`: 3,
+		// Editor and copy leftovers like config.php~ or index.php.save
+		`(~|\.orig|\.save|\.swp|\.copy|\.\d+)$`: 4,
+	}
+)
+
+// loadScoring reads the [Scoring] weights into s.
+func loadScoring(cfg *ini.File, s *Settings) error {
+	scoring := cfg.Section("Scoring")
+	s.DefaultWeight = scoring.Key("DefaultWeight").MustInt(1)
+
+	extensions, err := loadWeights(cfg, "Scoring.Extensions", defaultExtensionWeights)
+	if err != nil {
+		return err
+	}
+	s.ExtensionWeights = make(map[string]int, len(extensions))
+	s.scoredExtensions = nil
+	for ext, weight := range extensions {
+		ext = strings.TrimPrefix(ext, ".")
+		s.ExtensionWeights[ext] = weight
+		s.scoredExtensions = append(s.scoredExtensions, ext)
+	}
+	if s.KeywordWeights, err = loadWeights(cfg, "Scoring.Keywords", defaultKeywordWeights); err != nil {
+		return err
+	}
+
+	patterns, err := loadWeights(cfg, "Scoring.Patterns", defaultPatternWeights)
+	if err != nil {
+		return err
+	}
+	s.PatternWeights = nil
+	for pattern, weight := range patterns {
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return fmt.Errorf("[Scoring.Patterns] %v", err)
+		}
+		s.PatternWeights = append(s.PatternWeights, PatternWeight{Pattern: re, Weight: weight})
+	}
+	return nil
+}
+
+// loadWeights reads a section of name = weight keys, falling back to
+// defaults when the section doesn't exist.
+func loadWeights(cfg *ini.File, name string, defaults map[string]int) (map[string]int, error) {
+	weights := make(map[string]int)
+	section, err := cfg.GetSection(name)
+	if err != nil {
+		for k, w := range defaults {
+			weights[k] = w
+		}
+		return weights, nil
+	}
+
+	for _, key := range section.Keys() {
+		weight, err := key.Int()
+		if err != nil {
+			return nil, fmt.Errorf("[%s] %s: weight must be a number", name, key.Name())
+		}
+		weights[strings.ToLower(key.Name())] = weight
+	}
+	return weights, nil
+}
+
+// ScoreURL rates how interesting the file at raw looks: the weight of its
+// extension, plus the weights of the keywords among the words of its path
+// and of the patterns its file name matches.
+func ScoreURL(raw string, cfg *Settings) int {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return 0
+	}
+
+	score := cfg.DefaultWeight
+	if ext := URLExtension(raw, cfg.scoredExtensions, cfg.QueryExtensions); ext != "" {
+		score = cfg.ExtensionWeights[ext]
+	}
+
+	words := pathWords(u.Path)
+	for keyword, weight := range cfg.KeywordWeights {
+		if hasKeyword(words, keyword) {
+			score += weight
+		}
+	}
+
+	name := path.Base(u.Path)
+	for _, p := range cfg.PatternWeights {
+		if p.Pattern.MatchString(name) {
+			score += p.Weight
+		}
+	}
+	return score
+}
+
+// rankRecords returns a copy of records with their scores set, highest
+// score first. Records with the same score keep their order.
+func rankRecords(records []CDXRecord) []CDXRecord {
+	ranked := make([]CDXRecord, len(records))
+	copy(ranked, records)
+
+	cfg, err := CurrentSettings()
+	if err != nil {
+		return ranked
+	}
+	for i := range ranked {
+		ranked[i].Score = ScoreURL(ranked[i].URL, cfg)
+	}
+	sortByScore(ranked)
+	return ranked
+}
+
+// sortByScore orders records by their score, highest first.
+func sortByScore(records []CDXRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Score > records[j].Score
+	})
+}
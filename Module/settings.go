@@ -0,0 +1,239 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+const DefaultExtensions = "xls, xml, xlsx, json, pdf, sql, doc, docx, pptx, txt, zip, tar.gz, tgz, bak, 7z, rar, log, cache, secret, db, backup, yml, gz, config, csv, yaml, md, md5, exe, dll, bin, ini, bat, sh, tar, deb, rpm, iso, img, apk, msi, dmg, tmp, crt, pem, key, pub, asc"
+
+// DefaultExtensionPattern is the regex mode equivalent of DefaultExtensions.
+const DefaultExtensionPattern = `\.(xls|xml|xlsx|json|pdf|sql|doc|docx|pptx|txt|zip|tar\.gz|tgz|bak|7z|rar|log|cache|secret|db|backup|yml|gz|config|csv|yaml|md|md5|exe|dll|bin|ini|bat|sh|tar|deb|rpm|iso|img|apk|msi|dmg|tmp|crt|pem|key|pub|asc)`
+
+// Settings holds everything read from settings.ini, plus any overrides
+// applied from the command line before a run starts.
+type Settings struct {
+	BatchSize     int
+	MaxThreads    int
+	Timeout       int
+	DomainWorkers int
+	MaxPerHost    int
+	HostRate      float64
+	MimeTypes     []string
+	MinLength     int64
+	MaxLength     int64
+
+	// Extensions are matched against the URL path in MatchExtension mode,
+	// ExtensionPattern against the whole URL in MatchRegex mode.
+	ExtensionMode    string
+	Extensions       []string
+	QueryExtensions  bool
+	ExtensionPattern string
+	// Profiles are the [Profile.<name>] sections; the selected ones replace
+	// Extensions.
+	Profiles         []FilterProfile
+	SelectedProfiles []string
+
+	Sources                []string
+	WaybackEndpoint        string
+	WaybackSnapshot        string
+	CommonCrawlIndex       string
+	CommonCrawlCollections int
+
+	From    string
+	To      string
+	Scope   string
+	Exclude []string
+
+	StatusFilter         string
+	MimeFilter           string
+	ServerSideExtensions bool
+
+	Collapse    string
+	PageTimeout int
+	PageRetries int
+	ResumeDir   string
+
+	IgnoreScheme         bool
+	CollapseDefaultPorts bool
+	StripWWW             bool
+	StripTrailingDot     bool
+	QueryParams          string
+
+	CacheDir     string
+	CacheTTL     time.Duration
+	RefreshCache bool
+
+	MaxRequests      int
+	RateLimitRetries int
+	BackoffBase      int
+	MaxBackoff       int
+
+	ArchiveFallback bool
+	Soft404         bool
+	Soft404Probes   int
+	SignatureCheck  string
+	ResultsFile     string
+	StatusPolicy    StatusPolicy
+
+	// Redirect policy, see redirectPolicy.
+	MaxRedirects  int
+	RedirectCheck string
+	RedirectHosts []string
+	LoginPaths    []string
+
+	// Scoring weights, see ScoreURL.
+	DefaultWeight    int
+	ExtensionWeights map[string]int
+	KeywordWeights   map[string]int
+	PatternWeights   []PatternWeight
+	scoredExtensions []string
+
+	OutputFile string
+	NoDownload bool
+}
+
+var active *Settings
+
+// LoadSettings reads the given ini file, falling back to the built-in
+// defaults for any missing key.
+func LoadSettings(path string) (*Settings, error) {
+	cfg, err := ini.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := cfg.Section("BatchProcessing")
+	fetch := cfg.Section("Fetch")
+	filters := cfg.Section("Filters")
+	sources := cfg.Section("Sources")
+	query := cfg.Section("Query")
+	normalize := cfg.Section("Normalize")
+	extensions := cfg.Section("FileExtensions")
+	validation := cfg.Section("Validation")
+	s := &Settings{
+		BatchSize:     batch.Key("BatchSize").MustInt(10),
+		MaxThreads:    batch.Key("MaxThreads").MustInt(5),
+		Timeout:       batch.Key("Timeout").MustInt(15),
+		DomainWorkers: batch.Key("DomainWorkers").MustInt(1),
+		MaxPerHost:    batch.Key("MaxPerHost").MustInt(4),
+		HostRate:      batch.Key("HostRate").MustFloat64(5),
+		MimeTypes:     splitList(strings.ToLower(filters.Key("MimeTypes").String())),
+		MinLength:     filters.Key("MinLength").MustInt64(0),
+		MaxLength:     filters.Key("MaxLength").MustInt64(0),
+
+		ExtensionMode:    extensions.Key("Mode").In(MatchExtension, []string{MatchExtension, MatchRegex}),
+		Extensions:       parseExtensions(extensions.Key("Extensions").MustString(DefaultExtensions)),
+		QueryExtensions:  extensions.Key("QueryValues").MustBool(false),
+		ExtensionPattern: extensions.Key("Pattern").MustString(DefaultExtensionPattern),
+
+		Sources:                splitList(sources.Key("Enabled").MustString("wayback")),
+		WaybackEndpoint:        sources.Key("WaybackEndpoint").MustString(WaybackURL),
+		WaybackSnapshot:        sources.Key("WaybackSnapshot").MustString(WaybackSnapshotURL),
+		CommonCrawlIndex:       sources.Key("CommonCrawlIndex").MustString(CommonCrawlIndexURL),
+		CommonCrawlCollections: sources.Key("CommonCrawlCollections").MustInt(1),
+
+		From:    query.Key("From").String(),
+		To:      query.Key("To").String(),
+		Scope:   strings.ToLower(query.Key("Scope").MustString(ScopeWildcard)),
+		Exclude: splitList(strings.ToLower(query.Key("Exclude").String())),
+
+		StatusFilter:         query.Key("StatusFilter").String(),
+		MimeFilter:           query.Key("MimeFilter").String(),
+		ServerSideExtensions: query.Key("ServerSideExtensions").MustBool(false),
+
+		Collapse:    fetch.Key("Collapse").In("digest", []string{"digest", "urlkey"}),
+		PageTimeout: fetch.Key("PageTimeout").MustInt(120),
+		PageRetries: fetch.Key("PageRetries").MustInt(3),
+		ResumeDir:   fetch.Key("ResumeDir").MustString(".archseek/resume"),
+
+		IgnoreScheme:         normalize.Key("IgnoreScheme").MustBool(true),
+		CollapseDefaultPorts: normalize.Key("CollapseDefaultPorts").MustBool(true),
+		StripWWW:             normalize.Key("StripWWW").MustBool(true),
+		StripTrailingDot:     normalize.Key("StripTrailingDot").MustBool(true),
+		QueryParams:          normalize.Key("QueryParams").In(QueryKeep, []string{QueryKeep, QuerySort, QueryStrip}),
+
+		CacheDir: cfg.Section("Cache").Key("Dir").MustString(".archseek/cache"),
+		CacheTTL: cfg.Section("Cache").Key("TTL").MustDuration(24 * time.Hour),
+
+		MaxRequests:      fetch.Key("MaxRequests").MustInt(0),
+		RateLimitRetries: fetch.Key("RateLimitRetries").MustInt(5),
+		BackoffBase:      fetch.Key("BackoffBase").MustInt(2),
+		MaxBackoff:       fetch.Key("MaxBackoff").MustInt(300),
+
+		ArchiveFallback: validation.Key("ArchiveFallback").MustBool(false),
+		Soft404:         validation.Key("Soft404").MustBool(true),
+		Soft404Probes:   validation.Key("Soft404Probes").MustInt(3),
+		ResultsFile:     validation.Key("ResultsFile").MustString("validation_results.jsonl"),
+		SignatureCheck:  validation.Key("SignatureCheck").In(SignatureSkip, []string{SignatureOff, SignatureFlag, SignatureSkip}),
+
+		MaxRedirects:  validation.Key("MaxRedirects").MustInt(5),
+		RedirectCheck: validation.Key("RedirectCheck").In(RedirectRefuse, []string{RedirectAllow, RedirectFlag, RedirectRefuse}),
+		RedirectHosts: splitList(strings.ToLower(validation.Key("RedirectHosts").String())),
+		LoginPaths:    splitList(strings.ToLower(validation.Key("LoginPaths").MustString(DefaultLoginPaths))),
+
+		OutputFile: "valid_urls.txt",
+	}
+	// Older settings files hold a regular expression in Extensions
+	if value := extensions.Key("Extensions").String(); looksLikeRegex(value) && !extensions.HasKey("Pattern") {
+		s.ExtensionMode = MatchRegex
+		s.ExtensionPattern = value
+	}
+	if s.Profiles, err = loadProfiles(cfg); err != nil {
+		return nil, err
+	}
+	if err := s.SelectProfiles(splitList(extensions.Key("Profiles").String())); err != nil {
+		return nil, fmt.Errorf("[FileExtensions] Profiles: %v", err)
+	}
+	if s.StatusPolicy, err = loadStatusPolicy(cfg.Section("StatusPolicy")); err != nil {
+		return nil, err
+	}
+	if err := loadScoring(cfg, s); err != nil {
+		return nil, err
+	}
+	if err := ValidateTimestamp(s.From); err != nil {
+		return nil, fmt.Errorf("[Query] From: %v", err)
+	}
+	if err := ValidateTimestamp(s.To); err != nil {
+		return nil, fmt.Errorf("[Query] To: %v", err)
+	}
+	if err := ValidateScope(s.Scope); err != nil {
+		return nil, fmt.Errorf("[Query] Scope: %v", err)
+	}
+	return s, nil
+}
+
+// UseSettings makes s the settings used by every later fetch, filter and
+// validation call.
+func UseSettings(s *Settings) {
+	active = s
+	FileExtensions = s.ExtensionRegex()
+}
+
+// CurrentSettings returns the active settings, loading settings.ini on
+// first use.
+func CurrentSettings() (*Settings, error) {
+	if active != nil {
+		return active, nil
+	}
+	s, err := LoadSettings("settings.ini")
+	if err != nil {
+		return nil, err
+	}
+	UseSettings(s)
+	return active, nil
+}
+
+// splitList splits a comma separated setting, dropping empty entries.
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
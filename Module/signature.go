@@ -0,0 +1,244 @@
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Signature check modes for [Validation] SignatureCheck.
+const (
+	SignatureOff  = "off"  // don't look at the content
+	SignatureFlag = "flag" // keep mismatches, but mark them in the results
+	SignatureSkip = "skip" // drop mismatches
+)
+
+// signatureBytes is how much of a file is fetched to check its signature.
+const signatureBytes = 4096
+
+// magic is a byte sequence expected at a fixed offset.
+type magic struct {
+	offset int
+	bytes  string
+}
+
+var (
+	zipMagic = []magic{{0, "PK\x03\x04"}, {0, "PK\x05\x06"}}
+	oleMagic = []magic{{0, "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1"}}
+	gzMagic  = []magic{{0, "\x1F\x8B"}}
+	pemMagic = []magic{{0, "-----BEGIN "}}
+	// Binary certificates and keys are an ASN.1 SEQUENCE with a long length
+	certMagic = append([]magic{{0, "\x30\x82"}, {0, "\x30\x81"}}, pemMagic...)
+)
+
+// fileMagic lists the signatures a file with the extension may start with.
+var fileMagic = map[string][]magic{
+	"zip": zipMagic, "docx": zipMagic, "xlsx": zipMagic, "pptx": zipMagic,
+	"odt": zipMagic, "ods": zipMagic, "jar": zipMagic, "apk": zipMagic, "war": zipMagic,
+	"doc": oleMagic, "xls": oleMagic, "ppt": oleMagic, "msi": oleMagic,
+	"pdf":    {{0, "%PDF"}},
+	"7z":     {{0, "7z\xBC\xAF\x27\x1C"}},
+	"rar":    {{0, "Rar!\x1A\x07"}},
+	"gz":     gzMagic,
+	"tgz":    gzMagic,
+	"tar.gz": gzMagic,
+	"sql.gz": gzMagic,
+	"bz2":    {{0, "BZh"}},
+	"xz":     {{0, "\xFD7zXZ\x00"}},
+	"tar":    {{257, "ustar"}},
+	"sqlite": {{0, "SQLite format 3\x00"}},
+	"exe":    {{0, "MZ"}},
+	"dll":    {{0, "MZ"}},
+	"deb":    {{0, "!<arch>"}},
+	"rpm":    {{0, "\xED\xAB\xEE\xDB"}},
+	"pem":    pemMagic,
+	"crt":    certMagic,
+	"cer":    certMagic,
+	"der":    certMagic,
+	"key":    append([]magic{{0, "PuTTY-User-Key-File"}}, certMagic...),
+}
+
+// sqlHeaders are comments SQL dumps open with, compared lowercased. They
+// only count when nothing but comments fits in the fetched bytes.
+var sqlHeaders = []string{
+	"-- mysql dump", "-- mariadb dump", "-- postgresql database dump", "-- phpmyadmin",
+}
+
+// sqlStatement matches the start of a SQL statement in lowercased text.
+// Keywords that are also common English words need the rest of the
+// statement's shape, so a page saying "use this link" doesn't pass.
+var sqlStatement = regexp.MustCompile(`(?s)^(?:` +
+	`(?:create|alter|drop)\s+(?:or\s+replace\s+|unique\s+|temporary\s+|temp\s+|if\s+(?:not\s+)?exists\s+)*` +
+	`(?:table|index|view|database|schema|sequence|function|procedure|trigger|type|user|role|extension|event)\b` +
+	`|insert\s+(?:ignore\s+)?into\s|replace\s+into\s|delete\s+from\s|truncate\s+(?:table\s+)?\S+\s*;` +
+	`|set\s+(?:names\s|@|session\s|global\s|[\w.]+\s*=|[\w.]+\s+to\s)` +
+	`|begin(?:\s+transaction)?\s*;|start\s+transaction\s*;|commit\s*;|lock\s+tables\s|unlock\s+tables\s*;|pragma\s+\w+` +
+	`|use\s+[` + "`" + `"\[]?[\w-]+[` + "`" + `"\]]?\s*;` +
+	`|select\s[^;]{0,500}?\sfrom\s[^;]{0,500};|update\s+\S+\s+set\s[^;]{0,500};` +
+	`|grant\s[^;]{0,200}?\son\s[^;]{0,200};|revoke\s[^;]{0,200}?\son\s[^;]{0,200};` +
+	`|with\s+(?:recursive\s+)?\w+\s+as\s*\(|copy\s+\S+.{0,200}?\sfrom\s+stdin` +
+	`)`)
+
+// contentTypes lists the Content-Type prefixes that fit the extension, for
+// content matching its magic bytes as well as for content that can't be
+// verified. A generic binary type always fits.
+var contentTypes = map[string][]string{
+	"pdf":    {"application/pdf", "application/x-pdf"},
+	"zip":    {"application/zip", "application/x-zip", "multipart/x-zip"},
+	"docx":   {"application/vnd.openxmlformats-officedocument", "application/zip"},
+	"xlsx":   {"application/vnd.openxmlformats-officedocument", "application/zip"},
+	"pptx":   {"application/vnd.openxmlformats-officedocument", "application/zip"},
+	"doc":    {"application/msword", "application/vnd.ms-word", "application/vnd.ms-office"},
+	"xls":    {"application/vnd.ms-excel", "application/msexcel", "application/x-msexcel", "application/x-excel", "application/vnd.ms-office"},
+	"gz":     {"application/gzip", "application/x-gzip", "application/x-tar", "application/x-gtar", "application/x-compressed"},
+	"tgz":    {"application/gzip", "application/x-gzip", "application/x-tar", "application/x-gtar", "application/x-compressed"},
+	"tar.gz": {"application/gzip", "application/x-gzip", "application/x-tar", "application/x-gtar", "application/x-compressed"},
+	"7z":     {"application/x-7z-compressed"},
+	"rar":    {"application/x-rar", "application/vnd.rar"},
+	"json":   {"application/json", "text/json", "text/plain"},
+	"xml":    {"application/xml", "text/xml", "text/plain"},
+}
+
+// genericTypes fit any file.
+var genericTypes = []string{
+	"", "application/octet-stream", "binary/octet-stream", "application/binary",
+	"application/download", "application/force-download", "application/x-download",
+}
+
+// htmlExtensions are files that may well be HTML.
+var htmlExtensions = []string{"html", "htm", "xhtml", "shtml", "php", "asp", "aspx", "jsp"}
+
+// signatureExtensions are the extensions with something to check.
+var signatureExtensions = func() []string {
+	extensions := []string{"sql"}
+	for ext := range fileMagic {
+		extensions = append(extensions, ext)
+	}
+	for ext := range contentTypes {
+		if _, ok := fileMagic[ext]; !ok {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}()
+
+// checkSignature fetches the first bytes of rawURL and returns why they
+// don't look like a file with the URL's extension, or "" if they do or the
+// check can't tell.
+func checkSignature(client *http.Client, rawURL string, queryValues bool) string {
+	if URLExtension(rawURL, htmlExtensions, false) != "" {
+		return ""
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", signatureBytes-1))
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return ""
+	}
+
+	// The server may ignore the range, so don't read past it either way
+	head, err := io.ReadAll(io.LimitReader(resp.Body, signatureBytes))
+	if err != nil && len(head) == 0 {
+		return ""
+	}
+
+	ext := URLExtension(rawURL, signatureExtensions, queryValues)
+	return signatureMismatch(ext, resp.Header.Get("Content-Type"), head)
+}
+
+// signatureMismatch compares the start of a file and its Content-Type with
+// what the extension promises. A Content-Type fitting the file type is
+// required even when the content matches.
+func signatureMismatch(ext, contentType string, head []byte) string {
+	if looksLikeHTML(head) {
+		return "HTML page instead of the file"
+	}
+
+	verified := false
+	if ext == "sql" {
+		if !looksLikeSQL(head) {
+			return "no SQL statement at the start of the file"
+		}
+		verified = true
+	} else if signatures, ok := fileMagic[ext]; ok {
+		for _, m := range signatures {
+			if len(head) >= m.offset+len(m.bytes) && string(head[m.offset:m.offset+len(m.bytes)]) == m.bytes {
+				verified = true
+				break
+			}
+		}
+		if !verified {
+			return fmt.Sprintf("content doesn't start like a .%s file", ext)
+		}
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	if expected, ok := contentTypes[ext]; ok && !containsString(genericTypes, mediaType) && !hasAnyPrefix(mediaType, expected) {
+		return fmt.Sprintf("served as %s, not a .%s type", mediaType, ext)
+	}
+	if mediaType == "text/html" && !verified {
+		return "served as text/html"
+	}
+	return ""
+}
+
+// looksLikeSQL reports whether the first thing in head after blank lines and
+// comments is a SQL statement.
+func looksLikeSQL(head []byte) bool {
+	text := strings.ToLower(string(bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF"))))
+	for text != "" {
+		text = strings.TrimLeft(text, " \t\r\n")
+		switch {
+		case strings.HasPrefix(text, "/*!"):
+			// MySQL runs these versioned comments as statements
+			return true
+		case strings.HasPrefix(text, "/*"):
+			end := strings.Index(text, "*/")
+			if end < 0 {
+				text = ""
+				continue
+			}
+			text = text[end+2:]
+		case strings.HasPrefix(text, "--"), strings.HasPrefix(text, "#"):
+			_, rest, _ := strings.Cut(text, "\n")
+			text = rest
+		default:
+			return sqlStatement.MatchString(text)
+		}
+	}
+
+	// Long comment headers can fill the fetched bytes
+	lower := strings.ToLower(string(head))
+	for _, header := range sqlHeaders {
+		if strings.Contains(lower, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksLikeHTML reports whether head is the start of an HTML document.
+func looksLikeHTML(head []byte) bool {
+	text := bytes.TrimLeft(bytes.TrimPrefix(head, []byte("\xEF\xBB\xBF")), " \t\r\n")
+	if len(text) > 64 {
+		text = text[:64]
+	}
+	lower := bytes.ToLower(text)
+	return bytes.HasPrefix(lower, []byte("<!doctype html")) || bytes.HasPrefix(lower, []byte("<html")) ||
+		bytes.HasPrefix(lower, []byte("<head")) || bytes.HasPrefix(lower, []byte("<body"))
+}
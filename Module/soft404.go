@@ -0,0 +1,206 @@
+package module
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// maxFingerprintBody bounds how much of a response is read to fingerprint it.
+const maxFingerprintBody = 256 * 1024
+
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// responsePrint identifies a page well enough to tell a host's generic
+// "not found" or login page apart from a real file.
+type responsePrint struct {
+	Status      int
+	Length      int64
+	Hash        string
+	Title       string
+	ContentType string
+}
+
+// hostBaseline holds how a host answers paths that don't exist. It is empty
+// when the host answers them with 404 or 410; a 200 page, or a 401/403 (or
+// a redirect validation stops at) for every path, ends up in it.
+type hostBaseline struct {
+	once   sync.Once
+	prints []responsePrint
+}
+
+// soft404Detector probes every host once per run and compares candidates
+// against its baseline.
+type soft404Detector struct {
+	mu     sync.Mutex
+	hosts  map[string]*hostBaseline
+	probes int
+}
+
+var soft404 = &soft404Detector{hosts: make(map[string]*hostBaseline)}
+
+// setProbes sets how many random paths each host is probed with.
+func (d *soft404Detector) setProbes(probes int) {
+	d.mu.Lock()
+	d.probes = probes
+	d.mu.Unlock()
+}
+
+// baseline returns the baseline of u's host, probing it on first use.
+// Concurrent callers for the same host wait for the same probe.
+func (d *soft404Detector) baseline(client *http.Client, u *url.URL) *hostBaseline {
+	origin := u.Scheme + "://" + u.Host
+	d.mu.Lock()
+	b, ok := d.hosts[origin]
+	if !ok {
+		b = &hostBaseline{}
+		d.hosts[origin] = b
+	}
+	probes := d.probes
+	d.mu.Unlock()
+
+	b.once.Do(func() {
+		for _, p := range probePaths(probes) {
+			fp, err := fingerprint(client, origin+p)
+			if err != nil || !baselineStatus(fp.Status) {
+				continue
+			}
+			b.prints = append(b.prints, fp)
+		}
+		if len(b.prints) > 0 {
+			cyan.Print("[INFO] ")
+			fmt.Printf("%s answers missing paths with status %d, checking its files for soft 404s\n", origin, b.prints[0].Status)
+		}
+	})
+	return b
+}
+
+// check returns why the file at rawURL looks like its host's answer to a
+// missing path, or "" if it doesn't.
+func (d *soft404Detector) check(client *http.Client, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	b := d.baseline(client, u)
+	if len(b.prints) == 0 {
+		return ""
+	}
+
+	// A file that can't be fetched now is left to the download retries
+	candidate, err := fingerprint(client, rawURL)
+	if err != nil {
+		return ""
+	}
+	for _, missing := range b.prints {
+		if candidate.Status != missing.Status {
+			continue
+		}
+		switch {
+		case candidate.Hash == missing.Hash:
+			return "soft 404: same body as a missing path"
+		case candidate.Title != "" && candidate.Title == missing.Title:
+			return fmt.Sprintf("soft 404: same title %q as a missing path", candidate.Title)
+		case isHTML(candidate.ContentType) && isHTML(missing.ContentType) && similarLength(candidate.Length, missing.Length):
+			return fmt.Sprintf("soft 404: HTML of %d bytes, a missing path gives %d", candidate.Length, missing.Length)
+		// Error pages like S3's AccessDenied carry a request ID, so their
+		// hash changes every time
+		case candidate.Status >= 300 && candidate.ContentType == missing.ContentType && similarLength(candidate.Length, missing.Length):
+			return fmt.Sprintf("soft 404: status %d like a missing path", candidate.Status)
+		}
+	}
+	return ""
+}
+
+// baselineStatus reports whether a host answering a missing path with status
+// makes the same status meaningless for real paths.
+func baselineStatus(status int) bool {
+	if status == http.StatusNotFound || status == http.StatusGone {
+		return false
+	}
+	return status < 500
+}
+
+// probePaths returns count random paths that shouldn't exist on any host.
+func probePaths(count int) []string {
+	shapes := []string{"/%s", "/%s.html", "/%s/%s.bak", "/%s.php", "/%s/"}
+	var paths []string
+	for i := 0; i < count; i++ {
+		shape := shapes[i%len(shapes)]
+		paths = append(paths, strings.ReplaceAll(shape, "%s", randomToken()))
+	}
+	return paths
+}
+
+func randomToken() string {
+	buf := make([]byte, 8)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// fingerprint fetches rawURL, following redirects, and summarizes the
+// response. Only the start of the body is read. Echoes of the requested
+// path are removed before hashing, so error pages quoting it still match.
+func fingerprint(client *http.Client, rawURL string) (responsePrint, error) {
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return responsePrint{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxFingerprintBody))
+	if err != nil {
+		return responsePrint{}, err
+	}
+
+	fp := responsePrint{
+		Status:      resp.StatusCode,
+		Length:      resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	if fp.Length < 0 {
+		fp.Length = int64(len(body))
+	}
+
+	text := string(body)
+	if u, err := url.Parse(rawURL); err == nil {
+		for _, echo := range []string{u.RequestURI(), u.EscapedPath(), u.Path} {
+			if len(echo) > 1 {
+				text = strings.ReplaceAll(text, echo, "")
+			}
+		}
+	}
+	sum := sha256.Sum256([]byte(text))
+	fp.Hash = hex.EncodeToString(sum[:])
+	if m := titlePattern.FindStringSubmatch(text); m != nil {
+		fp.Title = strings.Join(strings.Fields(m[1]), " ")
+	}
+	return fp, nil
+}
+
+func isHTML(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(contentType), "text/html")
+}
+
+// similarLength reports whether two page sizes are within 5% of each other.
+func similarLength(a, b int64) bool {
+	if a <= 0 || b <= 0 {
+		return false
+	}
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	larger := a
+	if b > a {
+		larger = b
+	}
+	return diff*20 <= larger
+}
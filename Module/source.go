@@ -0,0 +1,123 @@
+package module
+
+import (
+	"fmt"
+	"strings"
+
+	"archseek/loader"
+)
+
+// URLSource lists the archived URLs of a domain.
+type URLSource interface {
+	// Name is shown in progress messages.
+	Name() string
+	// Stream passes every capture found for target to emit and returns how
+	// many were read.
+	Stream(target Target, emit func(CDXRecord)) (int, error)
+}
+
+// EnabledSources builds the sources listed under [Sources] in settings.ini.
+func EnabledSources() ([]URLSource, error) {
+	cfg, err := CurrentSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	var sources []URLSource
+	for _, name := range cfg.Sources {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "wayback":
+			sources = append(sources, &WaybackSource{Endpoint: cfg.WaybackEndpoint})
+		case "commoncrawl":
+			sources = append(sources, &CommonCrawlSource{
+				IndexURL:    cfg.CommonCrawlIndex,
+				Collections: cfg.CommonCrawlCollections,
+			})
+		default:
+			return nil, fmt.Errorf("unknown URL source %q", name)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no URL source enabled")
+	}
+	return sources, nil
+}
+
+// streamSource runs one source with a loader and reports how many captures
+// it returned.
+func streamSource(source URLSource, target Target, emit func(CDXRecord)) (int, error) {
+	domain := target.Domain
+
+	// Spinners of parallel domains would overwrite each other
+	cfg, _ := CurrentSettings()
+	loader := loader.New("[INFO] Fetching URLs from " + source.Name())
+	if cfg.DomainWorkers <= 1 {
+		loader.Start()
+		defer loader.Stop()
+	}
+
+	count, err := source.Stream(target, emit)
+	loader.Stop()
+	if err != nil {
+		red.Print("[ERROR] ")
+		fmt.Printf("Failed to fetch URLs from %s for %s: %v\n", source.Name(), domain, err)
+		return count, err
+	}
+
+	green.Print("[SUCCESS] ")
+	red.Printf("%d ", count)
+	fmt.Printf("captures retrieved from %s for %s\n", source.Name(), domain)
+	return count, nil
+}
+
+// FetchFilteredURLs streams the listing of every enabled source for target
+// through the file type filter, so only matching records are ever held in
+// memory. Captures of the same URL from different sources are merged.
+func FetchFilteredURLs(target Target) ([]CDXRecord, error) {
+	match, err := newRecordFilter()
+	if err != nil {
+		red.Print("[ERROR] ")
+		fmt.Printf("Failed to load settings: %v\n", err)
+		return nil, err
+	}
+	sources, err := EnabledSources()
+	if err != nil {
+		red.Print("[ERROR] ")
+		fmt.Printf("Failed to load sources: %v\n", err)
+		return nil, err
+	}
+	cfg, _ := CurrentSettings()
+
+	// Keep the results of the other sources when one fails
+	var fetchErr error
+	set := newRecordSet()
+	excluded := 0
+	for _, source := range sources {
+		name := source.Name()
+		_, err := streamSource(source, target, func(r CDXRecord) {
+			if len(cfg.Exclude) > 0 && Excluded(recordHost(r), cfg.Exclude) {
+				excluded++
+				return
+			}
+			if match(r) {
+				r.FoundIn = []string{name}
+				r.Target = &target
+				set.add(r)
+			}
+		})
+		if err != nil {
+			fetchErr = err
+		}
+	}
+
+	if excluded > 0 {
+		cyan.Print("[INFO] ")
+		red.Printf("%d ", excluded)
+		fmt.Println("captures on excluded hosts skipped")
+	}
+	cyan.Print("[INFO] ")
+	red.Printf("%d ", len(set.records))
+	fmt.Println("URLs matching file types")
+
+	return set.records, fetchErr
+}
@@ -0,0 +1,77 @@
+package module
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ini.v1"
+)
+
+// Status policy classes, see StatusPolicy.
+const (
+	PolicyDownload   = "download"    // valid, saved and downloaded
+	PolicyReportOnly = "report-only" // saved as a finding, not downloaded
+	PolicyDiscard    = "discard"     // dropped
+)
+
+// StatusPolicy maps final response statuses to a policy class. Rules are
+// exact codes like 403 or classes like 3xx; an exact code wins over its
+// class, and anything unlisted is discarded.
+type StatusPolicy map[string]string
+
+// loadStatusPolicy reads the [StatusPolicy] section.
+func loadStatusPolicy(section *ini.Section) (StatusPolicy, error) {
+	policy := make(StatusPolicy)
+	for _, class := range []struct{ key, policy, defaults string }{
+		{"Download", PolicyDownload, "200, 206"},
+		{"ReportOnly", PolicyReportOnly, "401, 403"},
+		{"Discard", PolicyDiscard, ""},
+	} {
+		for _, rule := range splitList(strings.ToLower(section.Key(class.key).MustString(class.defaults))) {
+			if !validStatusRule(rule) {
+				return nil, fmt.Errorf("[StatusPolicy] %s: %q is not a status code or class like 4xx", class.key, rule)
+			}
+			policy[rule] = class.policy
+		}
+	}
+	return policy, nil
+}
+
+func validStatusRule(rule string) bool {
+	if len(rule) != 3 || rule[0] < '1' || rule[0] > '5' {
+		return false
+	}
+	if rule[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(rule)
+	return err == nil
+}
+
+// Classify returns the policy class of status.
+func (p StatusPolicy) Classify(status int) string {
+	if class, ok := p.rule(status); ok {
+		return class
+	}
+	return PolicyDiscard
+}
+
+// rule returns the class of the rule listing status, if any.
+func (p StatusPolicy) rule(status int) (string, bool) {
+	code := strconv.Itoa(status)
+	if class, ok := p[code]; ok {
+		return class, true
+	}
+	if class, ok := p[code[:1]+"xx"]; ok && len(code) == 3 {
+		return class, true
+	}
+	return "", false
+}
+
+// stopsAt reports whether validation should stop at a redirect with status
+// and classify it, instead of following it to the final answer.
+func (p StatusPolicy) stopsAt(status int) bool {
+	_, ok := p.rule(status)
+	return ok && status >= 300 && status < 400
+}
@@ -0,0 +1,369 @@
+package module
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Target is one domain to scan, with the options that may differ per
+// domain in a domain list file.
+type Target struct {
+	Domain string
+	// Path limits a prefix scope to URLs below it, e.g. "/docs/".
+	Path string
+	// Scope is ScopeExact, ScopeWildcard or ScopePrefix. Empty falls back
+	// to settings.ini.
+	Scope string
+	// From and To bound the captures by timestamp (yyyy, yyyymm up to the
+	// full yyyyMMddhhmmss). Empty values fall back to settings.ini.
+	From string
+	To   string
+}
+
+// Scope modes deciding which URLs of a target are queried.
+const (
+	ScopeExact    = "exact"    // only the host itself
+	ScopeWildcard = "wildcard" // the host and every subdomain
+	ScopePrefix   = "prefix"   // only URLs below the target's path
+)
+
+// DomainTargets parses bare domains, URLs or wildcard entries into
+// targets, see ParseTarget.
+func DomainTargets(domains []string) ([]Target, error) {
+	targets := make([]Target, 0, len(domains))
+	for _, d := range domains {
+		target, err := ParseTarget(d)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// ParseTarget turns one entry of a domain list into a target. It accepts a
+// bare host, a full URL (the scheme, credentials and port are dropped, the
+// path is kept for the prefix scope) or a wildcard like *.example.com.
+// Internationalized names are converted to punycode.
+func ParseTarget(entry string) (Target, error) {
+	var target Target
+	rest := strings.TrimSpace(entry)
+
+	if i := strings.Index(rest, "://"); i >= 0 {
+		rest = rest[i+3:]
+	}
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		if rest[i] == '/' {
+			target.Path = rest[i:]
+			if j := strings.IndexAny(target.Path, "?#"); j >= 0 {
+				target.Path = target.Path[:j]
+			}
+		}
+		rest = rest[:i]
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		rest = rest[i+1:]
+	}
+	if host, _, err := net.SplitHostPort(rest); err == nil {
+		rest = host
+	}
+	if wildcard, ok := strings.CutPrefix(rest, "*."); ok {
+		rest = wildcard
+		target.Scope = ScopeWildcard
+	}
+	rest = strings.TrimSuffix(rest, ".")
+
+	host, err := ValidateHostname(rest)
+	if err != nil {
+		return Target{}, err
+	}
+	target.Domain = host
+	return target, nil
+}
+
+// ValidateHostname checks host and returns it in lowercase ASCII, with
+// internationalized labels punycode encoded. IP addresses are accepted.
+func ValidateHostname(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("empty hostname")
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid hostname %q: %v", host, err)
+	}
+	if len(ascii) > 253 || !strings.Contains(ascii, ".") {
+		return "", fmt.Errorf("invalid hostname %q", host)
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", fmt.Errorf("invalid hostname %q", host)
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-') {
+				return "", fmt.Errorf("invalid hostname %q", host)
+			}
+		}
+	}
+	return ascii, nil
+}
+
+// ParseTargets reads a domain list. It accepts a JSON array of entries
+// (strings, or objects with a domain/host/url field plus optional
+// from/to/scope), or one entry per line. Lines may be CSV, in which case
+// the first column is used, columns of key=value options are applied and a
+// header row is skipped. Text after # is a comment. A line may carry
+// options after the entry:
+//
+//	example.com from=2015 to=201806   # before the migration
+//	https://example.com/docs/ scope=prefix
+//	*.example.org
+func ParseTargets(r io.Reader) ([]Target, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if text := bytes.TrimSpace(data); len(text) > 0 && text[0] == '[' {
+		return parseJSONTargets(text)
+	}
+
+	var targets []Target
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNo := 0
+	seenEntry := false
+	for scanner.Scan() {
+		lineNo++
+		line := stripComment(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var entry string
+		var options []string
+		if strings.Contains(line, ",") {
+			record, err := csv.NewReader(strings.NewReader(line)).Read()
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+			if fields := strings.Fields(record[0]); len(fields) > 0 {
+				entry, options = fields[0], fields[1:]
+			}
+			// Other columns are ignored unless they hold options
+			for _, column := range record[1:] {
+				if strings.Contains(column, "=") {
+					options = append(options, strings.Fields(column)...)
+				}
+			}
+		} else {
+			fields := strings.Fields(line)
+			entry, options = fields[0], fields[1:]
+		}
+
+		target, err := ParseTarget(entry)
+		if err != nil {
+			// The first row of a CSV export is usually a header like "domain"
+			if !seenEntry && strings.Contains(line, ",") && !strings.Contains(entry, ".") {
+				seenEntry = true
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		seenEntry = true
+
+		for _, option := range options {
+			key, value, ok := strings.Cut(option, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key=value, got %q", lineNo, option)
+			}
+			if err := target.setOption(key, value); err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNo, err)
+			}
+		}
+		if err := target.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, scanner.Err()
+}
+
+// stripComment drops a # comment and surrounding space from line. A # inside
+// an entry, like a URL fragment, is kept.
+func stripComment(line string) string {
+	for i, c := range line {
+		if c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t') {
+			line = line[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+func parseJSONTargets(data []byte) ([]Target, error) {
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("JSON target list: %v", err)
+	}
+
+	targets := make([]Target, 0, len(entries))
+	for i, raw := range entries {
+		var entry string
+		var options map[string]string
+
+		if err := json.Unmarshal(raw, &entry); err != nil {
+			var object map[string]string
+			if err := json.Unmarshal(raw, &object); err != nil {
+				return nil, fmt.Errorf("entry %d: expected a string or an object of strings", i+1)
+			}
+			for _, key := range []string{"domain", "host", "url", "target"} {
+				if object[key] != "" {
+					entry = object[key]
+					break
+				}
+			}
+			options = object
+		}
+
+		target, err := ParseTarget(entry)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i+1, err)
+		}
+		for _, key := range []string{"from", "to", "scope"} {
+			if value, ok := options[key]; ok {
+				target.setOption(key, value)
+			}
+		}
+		if err := target.validate(); err != nil {
+			return nil, fmt.Errorf("entry %d: %v", i+1, err)
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+func (t *Target) setOption(key, value string) error {
+	switch strings.ToLower(key) {
+	case "from":
+		t.From = value
+	case "to":
+		t.To = value
+	case "scope":
+		t.Scope = strings.ToLower(value)
+	default:
+		return fmt.Errorf("unknown option %q", key)
+	}
+	return nil
+}
+
+func (t Target) validate() error {
+	if err := ValidateTimestamp(t.From); err != nil {
+		return fmt.Errorf("from: %v", err)
+	}
+	if err := ValidateTimestamp(t.To); err != nil {
+		return fmt.Errorf("to: %v", err)
+	}
+	if err := ValidateScope(t.Scope); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateScope checks a scope mode. Empty means the configured default.
+func ValidateScope(scope string) error {
+	switch scope {
+	case "", ScopeExact, ScopeWildcard, ScopePrefix:
+		return nil
+	}
+	return fmt.Errorf("unknown scope %q, use exact, wildcard or prefix", scope)
+}
+
+// scope returns the target's scope mode, falling back to the settings.
+func (t Target) scope(cfg *Settings) string {
+	if t.Scope == "" {
+		return cfg.Scope
+	}
+	return t.Scope
+}
+
+// inScope reports whether host belongs to the target: the domain itself,
+// or one of its subdomains for a wildcard scope. A leading www. is ignored.
+func (t Target) inScope(host string, cfg *Settings) bool {
+	host, domain := scopeHost(host), scopeHost(t.Domain)
+	if host == domain {
+		return true
+	}
+	return t.scope(cfg) == ScopeWildcard && strings.HasSuffix(host, "."+domain)
+}
+
+// queryURL returns the url parameter of a CDX query for t.
+func (t Target) queryURL(cfg *Settings) string {
+	switch t.scope(cfg) {
+	case ScopeExact:
+		return t.Domain + "/*"
+	case ScopePrefix:
+		path := t.Path
+		if path == "" {
+			path = "/"
+		}
+		return t.Domain + path + "*"
+	}
+	return "*." + t.Domain + "/*"
+}
+
+// Excluded reports whether host matches one of the exclusion patterns. A
+// pattern is either an exact host or *.example.com for every subdomain of
+// example.com.
+func Excluded(host string, patterns []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, p := range patterns {
+		if suffix, ok := strings.CutPrefix(p, "*."); ok {
+			if strings.HasSuffix(host, "."+suffix) {
+				return true
+			}
+		} else if host == p {
+			return true
+		}
+	}
+	return false
+}
+
+// dateRange returns the timestamp bounds for t, using the settings for any
+// bound the target leaves empty.
+func (t Target) dateRange(cfg *Settings) (from, to string) {
+	from, to = t.From, t.To
+	if from == "" {
+		from = cfg.From
+	}
+	if to == "" {
+		to = cfg.To
+	}
+	return from, to
+}
+
+// ValidateTimestamp checks a CDX timestamp bound: 4 to 14 digits, so a
+// year, a month or anything down to the second. Empty means unbounded.
+func ValidateTimestamp(ts string) error {
+	if ts == "" {
+		return nil
+	}
+	if len(ts) < 4 || len(ts) > 14 {
+		return fmt.Errorf("timestamp %q must be 4 to 14 digits", ts)
+	}
+	for _, c := range ts {
+		if c < '0' || c > '9' {
+			return fmt.Errorf("timestamp %q must be digits only", ts)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,352 @@
+package module
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Error classes of a ValidationResult.
+const (
+	ErrorDNS        = "dns"        // the host no longer resolves
+	ErrorTimeout    = "timeout"    // no answer in time
+	ErrorTLS        = "tls"        // certificate or handshake failure
+	ErrorConnection = "connection" // refused, reset and other network errors
+	ErrorStatus     = "status"     // the server answered with a non-success status
+	ErrorSoft404    = "soft404"    // the answer matches the host's missing-path page
+	ErrorSignature  = "signature"  // the content doesn't match the extension
+	ErrorRedirect   = "redirect"   // a redirect the policy refused
+)
+
+// ValidationResult is what validating one candidate URL found out. Every
+// candidate gets one, valid or not.
+type ValidationResult struct {
+	URL   string `json:"url"`
+	Valid bool   `json:"valid"`
+	// Archived is set when the live file is gone and its archived snapshot
+	// is downloaded instead. Status still describes the live answer.
+	Archived bool `json:"archived,omitempty"`
+	// Policy is the StatusPolicy class of the final status.
+	Policy string `json:"policy,omitempty"`
+	// Status is the final status after redirects, 0 without an answer.
+	Status int `json:"status"`
+	// Method is HEAD, or GET for hosts refusing HEAD.
+	Method string `json:"method,omitempty"`
+	// RedirectChain lists every URL requested, the final one last, when
+	// the server redirected.
+	RedirectChain []string `json:"redirect_chain,omitempty"`
+	// RedirectFlag says why the redirects look suspicious, when they are
+	// only flagged.
+	RedirectFlag  string `json:"redirect_flag,omitempty"`
+	ContentType   string `json:"content_type,omitempty"`
+	ContentLength int64  `json:"content_length"`
+	LastModified  string `json:"last_modified,omitempty"`
+	ETag          string `json:"etag,omitempty"`
+	Server        string `json:"server,omitempty"`
+	LatencyMS     int64  `json:"latency_ms"`
+	ErrorClass    string `json:"error_class,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Attempts      int    `json:"attempts"`
+}
+
+// Valid reports whether validation accepted the record.
+func (r CDXRecord) Valid() bool {
+	return r.Validation != nil && r.Validation.Valid
+}
+
+// Downloadable reports whether the record is valid and its status policy
+// allows downloading it.
+func (r CDXRecord) Downloadable() bool {
+	return r.Valid() && r.Validation.Policy == PolicyDownload
+}
+
+// countDownloadable counts the records that may be downloaded.
+func countDownloadable(records []CDXRecord) int {
+	count := 0
+	for _, r := range records {
+		if r.Downloadable() {
+			count++
+		}
+	}
+	return count
+}
+
+// ValidRecords returns the records validation accepted, including the
+// report-only ones.
+func ValidRecords(records []CDXRecord) []CDXRecord {
+	var valid []CDXRecord
+	for _, r := range records {
+		if r.Valid() {
+			valid = append(valid, r)
+		}
+	}
+	return valid
+}
+
+// checkRecord validates one candidate and returns it with its Validation
+// result set. Network errors are retried; a host that no longer resolves
+// isn't.
+func checkRecord(client *http.Client, cfg *Settings, record CDXRecord) CDXRecord {
+	const maxRetries = 3
+	url := record.URL
+	result := &ValidationResult{URL: url}
+	record.Validation = result
+	record.Mismatch = ""
+	gone := false
+
+	// Redirects are judged against the domain the URL was found for; the
+	// archive check below keeps the unscoped client
+	live := client
+	if record.Target != nil {
+		scoped := *client
+		scoped.CheckRedirect = validationRedirects(cfg, record.Target)
+		live = &scoped
+	}
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		result.Attempts = attempt
+		// Latency leaves out the wait for a turn at the host
+		var queued time.Duration
+		start := time.Now()
+		resp, err := probeURL(withQueueWait(context.Background(), &queued), live, url)
+		result.LatencyMS = (time.Since(start) - queued).Milliseconds()
+		if err != nil {
+			result.ErrorClass, result.Error = classifyError(err), err.Error()
+			// Neither a refused redirect nor a host that no longer
+			// resolves comes back on retry
+			var redirectErr *RedirectError
+			if errors.As(err, &redirectErr) {
+				red.Print("[WARNING] ")
+				fmt.Printf("Rejected %s: %s\n", url, redirectErr)
+				result.Error, result.RedirectChain = redirectErr.Error(), redirectErr.Chain
+				gone = redirectErr.OffScope
+				break
+			}
+			if result.ErrorClass == ErrorDNS {
+				gone = true
+				break
+			}
+			if attempt == maxRetries {
+				red.Print("[ERROR] ")
+				fmt.Printf("Failed to validate %s after %d attempts: %v\n", url, maxRetries, err)
+			}
+			time.Sleep(time.Duration(attempt) * time.Second)
+			continue
+		}
+		resp.Body.Close()
+		result.fromResponse(resp)
+		if cfg.RedirectCheck == RedirectFlag {
+			if reason := chainProblem(cfg, record.Target, result.RedirectChain); reason != "" {
+				red.Print("[WARNING] ")
+				fmt.Printf("Flagged %s: %s\n", url, reason)
+				result.RedirectFlag = reason
+			}
+		}
+
+		// A 206 to our own ranged probe stands for the whole file
+		status := resp.StatusCode
+		if status == http.StatusPartialContent && resp.Request.Header.Get("Range") != "" {
+			status = http.StatusOK
+		}
+		result.Policy = cfg.StatusPolicy.Classify(status)
+		if result.Policy == PolicyDiscard {
+			result.ErrorClass = ErrorStatus
+			result.Error = resp.Status
+			gone = status == http.StatusNotFound || status == http.StatusGone
+			break
+		}
+		if result.Policy == PolicyReportOnly {
+			// A host denying every path, real or not, has nothing to report
+			if cfg.Soft404 {
+				if reason := soft404.check(live, url); reason != "" {
+					red.Print("[WARNING] ")
+					fmt.Printf("Rejected %s: %s\n", url, reason)
+					result.ErrorClass, result.Error = ErrorSoft404, reason
+					gone = true
+					break
+				}
+			}
+			cyan.Print("[INFO] ")
+			fmt.Printf("Reporting %s: %s\n", url, resp.Status)
+			result.Valid = true
+			record.Source = SourceLive
+			return record
+		}
+
+		// Hosts answering every path with 200 need a closer look
+		if cfg.Soft404 {
+			if reason := soft404.check(live, url); reason != "" {
+				red.Print("[WARNING] ")
+				fmt.Printf("Rejected %s: %s\n", url, reason)
+				result.ErrorClass, result.Error = ErrorSoft404, reason
+				gone = true
+				break
+			}
+		}
+		if cfg.SignatureCheck != SignatureOff {
+			if reason := checkSignature(live, url, cfg.QueryExtensions); reason != "" {
+				red.Print("[WARNING] ")
+				if cfg.SignatureCheck == SignatureSkip {
+					fmt.Printf("Skipped %s: %s\n", url, reason)
+					result.ErrorClass, result.Error = ErrorSignature, reason
+					gone = true
+					break
+				}
+				fmt.Printf("Flagged %s: %s\n", url, reason)
+				record.Mismatch = reason
+			}
+		}
+		result.ErrorClass, result.Error = "", ""
+		result.Valid = true
+		record.Source = SourceLive
+		return record
+	}
+
+	if gone && cfg.ArchiveFallback && archiveAvailable(client, record) {
+		result.ErrorClass, result.Error = "", ""
+		result.Valid = true
+		result.Archived = true
+		result.Policy = PolicyDownload
+		record.Source = SourceArchive
+	}
+	return record
+}
+
+// fromResponse copies what the final response says about the file.
+func (r *ValidationResult) fromResponse(resp *http.Response) {
+	r.Status = resp.StatusCode
+	r.Method = resp.Request.Method
+	r.ContentType = resp.Header.Get("Content-Type")
+	r.ContentLength = resp.ContentLength
+	if resp.StatusCode == http.StatusPartialContent {
+		// The full size is in Content-Range: bytes 0-0/12345
+		r.ContentLength = -1
+		if _, size, ok := strings.Cut(resp.Header.Get("Content-Range"), "/"); ok {
+			fmt.Sscan(size, &r.ContentLength)
+		}
+	}
+	r.LastModified = resp.Header.Get("Last-Modified")
+	r.ETag = resp.Header.Get("ETag")
+	r.Server = resp.Header.Get("Server")
+
+	// Each redirected request links to the response that caused it
+	r.RedirectChain = nil
+	if location, err := resp.Location(); err == nil && resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		// Validation stopped at a redirect a 3xx rule classifies
+		r.RedirectChain = []string{resp.Request.URL.String(), location.String()}
+	} else if resp.Request.Response != nil {
+		chain := []string{resp.Request.URL.String()}
+		for req := resp.Request; req.Response != nil; req = req.Response.Request {
+			chain = append([]string{req.Response.Request.URL.String()}, chain...)
+		}
+		r.RedirectChain = chain
+	}
+}
+
+// classifyError sorts a request error into one of the Error* classes.
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var redirectErr *RedirectError
+	switch {
+	case errors.As(err, &redirectErr):
+		return ErrorRedirect
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.As(err, &certErr), errors.As(err, &unknownAuthority), errors.As(err, &hostnameErr),
+		strings.Contains(err.Error(), "tls: "):
+		return ErrorTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	}
+	return ErrorConnection
+}
+
+// SaveResults writes the validation result of every record to filename as
+// JSON lines.
+func SaveResults(records []CDXRecord, filename string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, r := range records {
+		if r.Validation == nil {
+			continue
+		}
+		if err := encoder.Encode(r.Validation); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+
+	cyan.Print("[INFO] ")
+	fmt.Printf("Saved validation results to %s\n", filename)
+	return nil
+}
+
+// printOutcomes prints how many candidates ended in each outcome, so a 403
+// (there but protected) stands apart from a 404 (gone).
+func printOutcomes(records []CDXRecord) {
+	counts := make(map[string]int)
+	for _, r := range records {
+		if r.Validation == nil {
+			continue
+		}
+		counts[outcome(r.Validation)]++
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = fmt.Sprintf("%d %s", counts[k], k)
+	}
+	magenta.Print("[RESULT] ")
+	fmt.Printf("Validation outcomes: %s\n", strings.Join(parts, ", "))
+}
+
+// outcome names the result for printOutcomes.
+func outcome(v *ValidationResult) string {
+	switch {
+	case v.Archived:
+		return "archive"
+	case v.Valid && v.Policy == PolicyReportOnly:
+		return fmt.Sprintf("reported %d", v.Status)
+	case v.Valid:
+		return "valid"
+	case v.ErrorClass == ErrorStatus:
+		return fmt.Sprintf("status %d", v.Status)
+	}
+	return v.ErrorClass
+}
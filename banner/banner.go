@@ -4,8 +4,9 @@ import (
 	"fmt"
 	"github.com/fatih/color"
 	"strings"
-	"gopkg.in/ini.v1"
 	"time"
+
+	"archseek/Module"
 )
 
 type BannerConfig struct {
@@ -48,6 +49,19 @@ func centerText(text string, width int) string {
 	return strings.Join(centeredLines, "\n")
 }
 
+// printGrid prints items centered, 4 per line
+func printGrid(items []string, prefix string) {
+    var line strings.Builder
+    for i, item := range items {
+        line.WriteString(fmt.Sprintf("• %-8s", prefix+item))
+
+        if (i+1)%4 == 0 || i == len(items)-1 {
+            fmt.Println(color.New(color.FgHiWhite).Sprint(centerText(line.String(), 50)))
+            line.Reset()
+        }
+    }
+}
+
 func Print(config *BannerConfig) {
 	if config == nil {
 		config = DefaultConfig()
@@ -61,29 +75,32 @@ func Print(config *BannerConfig) {
 		fmt.Println(config.TextColor.Sprint(backgroundColorFunc(line)))
 	}
 
-// Display File Extensions
-cfg, err := ini.Load("settings.ini")
+// Display Filter Profiles
+cfg, err := module.CurrentSettings()
 if err == nil {
-    extensions := cfg.Section("FileExtensions").Key("Extensions").String()
-    extList := strings.Split(strings.ReplaceAll(extensions, "\\.", "."), "|")
-    
-    // Header dengan garis pemisah
     separator := centerText("──────────────────────────────────", 50)
     fmt.Println(color.New(color.FgHiBlack).Sprint(separator))
-    fmt.Println(color.New(color.FgHiCyan).Sprint(centerText("Supported File Extensions", 50)))
-    
-    // Format ekstensi dalam grid terpusat
-    var extLine strings.Builder
-    for i, ext := range extList {
-        extLine.WriteString(fmt.Sprintf("• %-8s", ext))
-        
-        // Membuat baris baru setiap 4 item
-        if (i+1)%4 == 0 || i == len(extList)-1 {
-            fmt.Println(color.New(color.FgHiWhite).Sprint(centerText(extLine.String(), 50)))
-            extLine.Reset()
+    fmt.Println(color.New(color.FgHiCyan).Sprint(centerText("Filter Profiles", 50)))
+
+    // The plain extension list applies while no profile is selected
+    if cfg.ExtensionMode == module.MatchRegex {
+        fmt.Println(color.New(color.FgHiGreen).Sprint(centerText("regex (active)", 50)))
+        fmt.Println(color.New(color.FgHiWhite).Sprint(centerText(cfg.ExtensionPattern, 50)))
+    } else if len(cfg.SelectedProfiles) == 0 {
+        fmt.Println(color.New(color.FgHiGreen).Sprint(centerText("default (active)", 50)))
+        printGrid(cfg.Extensions, "")
+    }
+    for _, profile := range cfg.Profiles {
+        if cfg.ProfileSelected(profile.Name) {
+            fmt.Println(color.New(color.FgHiGreen).Sprint(centerText(profile.Name+" (active)", 50)))
+        } else {
+            fmt.Println(color.New(color.FgHiBlack).Sprint(centerText(profile.Name, 50)))
         }
+        printGrid(profile.Include, "")
+        printGrid(profile.Keywords, "/")
+        printGrid(profile.Exclude, "!")
     }
-    
+
     fmt.Println(color.New(color.FgHiBlack).Sprint(separator))
 }
 
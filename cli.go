@@ -0,0 +1,304 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"archseek/Module"
+)
+
+// Exit codes returned by archseek.
+const (
+	ExitOK             = 0
+	ExitFatal          = 1
+	ExitNothingFound   = 2
+	ExitPartialFailure = 3
+)
+
+const usage = `Usage:
+  archseek                      interactive mode
+  archseek scan [options]       non-interactive scan
+  archseek import [options] file...
+                                validate and download URLs from existing
+                                lists instead of querying the archives
+
+Scan options:
+  -d, -domain string    domain to scan (e.g. example.com)
+  -l, -list string      domain list: one entry per line (plain, CSV or
+                        URLs, # comments, optional from= to= scope=)
+                        or a JSON array; - reads stdin
+  -o, -output string    output file, .json or .csv keep metadata (default "valid_urls.txt")
+  -results string       validation results of every candidate as JSON lines
+                        (overrides settings.ini, "" disables)
+  -c, -config string    settings file (default "settings.ini")
+  -threads int          max download threads (overrides settings.ini)
+  -timeout int          validation and download request timeout in seconds
+                        (overrides settings.ini)
+  -batch int            validation batch size (overrides settings.ini)
+  -parallel int         domains fetched at the same time (overrides settings.ini)
+  -no-download          validate URLs without downloading them
+  -archive-fallback     fetch the archived copy when the live file is gone
+  -refresh              ignore cached CDX listings and fetch them again
+  -sources string       comma separated URL sources: wayback, commoncrawl
+  -from string          only captures from this timestamp (yyyy[MM[dd...]])
+  -to string            only captures up to this timestamp
+  -scope string         exact, wildcard (subdomains) or prefix (domain/path)
+  -exclude string       comma separated hosts to skip, *.cdn.example.com for
+                        every subdomain
+  -p, -profile string   comma separated filter profiles from settings.ini,
+                        e.g. documents,archives (overrides settings.ini)
+  -i, -import string    comma separated URL lists to use instead of the
+                        archives: plain text (gau, waybackurls), JSON lines,
+                        an earlier .json result or a Burp XML export
+  -format string        import format: auto, text, jsonl or burp (default "auto")
+
+Exit codes:
+  0  valid URLs found
+  1  fatal error
+  2  nothing found
+  3  partial failure (some domains or downloads failed)
+`
+
+type scanOptions struct {
+	domain     string
+	list       string
+	output     string
+	results    string
+	config     string
+	threads    int
+	timeout    int
+	batch      int
+	parallel   int
+	noDownload bool
+	archive    bool
+	sources    string
+	refresh    bool
+	from       string
+	to         string
+	scope      string
+	exclude    string
+	profiles   string
+	imports    string
+	format     string
+}
+
+// runCLI parses args (without the program name) and runs the requested
+// command, returning the process exit code.
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		return ExitFatal
+	}
+
+	importing := false
+	switch args[0] {
+	case "scan":
+		args = args[1:]
+	case "import":
+		importing = true
+		args = args[1:]
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return ExitOK
+	default:
+		if !strings.HasPrefix(args[0], "-") {
+			red.Print("[ERROR] ")
+			fmt.Printf("Unknown command %q\n", args[0])
+			fmt.Fprint(os.Stderr, usage)
+			return ExitFatal
+		}
+	}
+
+	var opts scanOptions
+	fs := flag.NewFlagSet("scan", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	fs.StringVar(&opts.domain, "d", "", "")
+	fs.StringVar(&opts.domain, "domain", "", "")
+	fs.StringVar(&opts.list, "l", "", "")
+	fs.StringVar(&opts.list, "list", "", "")
+	fs.StringVar(&opts.output, "o", "", "")
+	fs.StringVar(&opts.output, "output", "", "")
+	fs.StringVar(&opts.results, "results", "", "")
+	fs.StringVar(&opts.config, "c", "settings.ini", "")
+	fs.StringVar(&opts.config, "config", "settings.ini", "")
+	fs.IntVar(&opts.threads, "threads", 0, "")
+	fs.IntVar(&opts.timeout, "timeout", 0, "")
+	fs.IntVar(&opts.batch, "batch", 0, "")
+	fs.IntVar(&opts.parallel, "parallel", 0, "")
+	fs.BoolVar(&opts.noDownload, "no-download", false, "")
+	fs.BoolVar(&opts.archive, "archive-fallback", false, "")
+	fs.StringVar(&opts.sources, "sources", "", "")
+	fs.BoolVar(&opts.refresh, "refresh", false, "")
+	fs.StringVar(&opts.from, "from", "", "")
+	fs.StringVar(&opts.to, "to", "", "")
+	fs.StringVar(&opts.scope, "scope", "", "")
+	fs.StringVar(&opts.exclude, "exclude", "", "")
+	fs.StringVar(&opts.profiles, "p", "", "")
+	fs.StringVar(&opts.profiles, "profile", "", "")
+	fs.StringVar(&opts.imports, "i", "", "")
+	fs.StringVar(&opts.imports, "import", "", "")
+	fs.StringVar(&opts.format, "format", module.ImportAuto, "")
+	if err := fs.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		return ExitFatal
+	}
+
+	settings, err := module.LoadSettings(opts.config)
+	if err != nil {
+		red.Print("[ERROR] ")
+		fmt.Printf("Failed to load settings: %v\n", err)
+		return ExitFatal
+	}
+	if opts.threads > 0 {
+		settings.MaxThreads = opts.threads
+	}
+	if opts.timeout > 0 {
+		settings.Timeout = opts.timeout
+	}
+	if opts.batch > 0 {
+		settings.BatchSize = opts.batch
+	}
+	if opts.parallel > 0 {
+		settings.DomainWorkers = opts.parallel
+	}
+	if opts.output != "" {
+		settings.OutputFile = opts.output
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "results" {
+			settings.ResultsFile = opts.results
+		}
+	})
+	settings.NoDownload = opts.noDownload
+	if opts.archive {
+		settings.ArchiveFallback = true
+	}
+	settings.RefreshCache = opts.refresh
+	if opts.sources != "" {
+		settings.Sources = nil
+		for _, name := range strings.Split(strings.ToLower(opts.sources), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				settings.Sources = append(settings.Sources, name)
+			}
+		}
+	}
+	for _, ts := range []string{opts.from, opts.to} {
+		if err := module.ValidateTimestamp(ts); err != nil {
+			red.Print("[ERROR] ")
+			fmt.Println(err)
+			return ExitFatal
+		}
+	}
+	if opts.from != "" {
+		settings.From = opts.from
+	}
+	if opts.to != "" {
+		settings.To = opts.to
+	}
+	if opts.scope != "" {
+		if err := module.ValidateScope(strings.ToLower(opts.scope)); err != nil {
+			red.Print("[ERROR] ")
+			fmt.Println(err)
+			return ExitFatal
+		}
+		settings.Scope = strings.ToLower(opts.scope)
+	}
+	for _, host := range strings.Split(strings.ToLower(opts.exclude), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			settings.Exclude = append(settings.Exclude, host)
+		}
+	}
+	if opts.profiles != "" {
+		if err := settings.SelectProfiles(strings.Split(opts.profiles, ",")); err != nil {
+			red.Print("[ERROR] ")
+			fmt.Println(err)
+			return ExitFatal
+		}
+	}
+	module.UseSettings(settings)
+
+	var imports []string
+	for _, path := range strings.Split(opts.imports, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			imports = append(imports, path)
+		}
+	}
+	if importing {
+		imports = append(imports, fs.Args()...)
+	}
+	if len(imports) > 0 {
+		if opts.domain != "" || opts.list != "" || (!importing && fs.NArg() > 0) {
+			red.Print("[ERROR] ")
+			fmt.Println("URL lists can't be combined with -d or -l")
+			return ExitFatal
+		}
+		return exitCode(module.ProcessImports(imports, strings.ToLower(opts.format)))
+	}
+	if importing {
+		red.Print("[ERROR] ")
+		fmt.Println("No URL list given")
+		fmt.Fprint(os.Stderr, usage)
+		return ExitFatal
+	}
+
+	var domains []string
+	if opts.domain != "" {
+		domains = append(domains, opts.domain)
+	}
+	domains = append(domains, fs.Args()...)
+	targets, err := module.DomainTargets(domains)
+	if err != nil {
+		red.Print("[ERROR] ")
+		fmt.Println(err)
+		return ExitFatal
+	}
+	if opts.list != "" {
+		listed, err := readTargetFile(opts.list)
+		if err != nil {
+			red.Print("[ERROR] ")
+			fmt.Printf("Failed to read %s: %v\n", opts.list, err)
+			return ExitFatal
+		}
+		targets = append(targets, listed...)
+	}
+	if len(targets) == 0 {
+		red.Print("[ERROR] ")
+		fmt.Println("No domains given, use -d or -l")
+		fmt.Fprint(os.Stderr, usage)
+		return ExitFatal
+	}
+
+	return exitCode(module.ProcessTargets(targets))
+}
+
+// exitCode maps the outcome of a run to one of the Exit* codes. A run is
+// fatal only when every domain failed without a valid URL; a domain that
+// failed partway may still have found some.
+func exitCode(summary *module.Summary) int {
+	switch {
+	case summary.SaveError != nil, summary.FailedDomains == summary.Domains && summary.Valid == 0:
+		return ExitFatal
+	case summary.FailedDomains > 0, summary.FailedDownloads > 0:
+		return ExitPartialFailure
+	case summary.Valid == 0:
+		return ExitNothingFound
+	}
+	return ExitOK
+}
+
+// readTargetFile parses a domain list, reading stdin when fileName is "-".
+func readTargetFile(fileName string) ([]module.Target, error) {
+	if fileName == "-" {
+		return module.ParseTargets(os.Stdin)
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return module.ParseTargets(file)
+}
@@ -4,12 +4,14 @@ go 1.21
 
 require (
 	github.com/briandowns/spinner v1.23.0
+	github.com/dustin/go-humanize v1.0.1
 	github.com/fatih/color v1.15.0
 	github.com/schollz/progressbar/v3 v3.13.1
+	golang.org/x/net v0.8.0
+	gopkg.in/ini.v1 v1.67.0
 )
 
 require (
-	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
 	github.com/mattn/go-runewidth v0.0.14 // indirect
@@ -18,5 +20,5 @@ require (
 	github.com/schollz/progressbar v1.0.0 // indirect
 	golang.org/x/sys v0.6.0 // indirect
 	golang.org/x/term v0.6.0 // indirect
-	gopkg.in/ini.v1 v1.67.0 // indirect
+	golang.org/x/text v0.8.0 // indirect
 )
@@ -18,6 +18,10 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
 	banner.Print(banner.DefaultConfig())
 
 
@@ -31,29 +35,34 @@ func main() {
 		scanner.Scan()
 		fileName := strings.TrimSpace(scanner.Text())
 
-		file, err := os.Open(fileName)
+		var targets []module.Target
+		var err error
+		if fileName == "-" {
+			// The prompt scanner may already hold the rest of stdin
+			var rest strings.Builder
+			for scanner.Scan() {
+				rest.WriteString(scanner.Text() + "\n")
+			}
+			targets, err = module.ParseTargets(strings.NewReader(rest.String()))
+		} else {
+			targets, err = readTargetFile(fileName)
+		}
 		if err != nil {
 			red.Print("[ERROR] ")
-			fmt.Printf("File %s not found\n", fileName)
-			return
-		}
-		defer file.Close()
-
-		var domains []string
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			line := strings.TrimSpace(scanner.Text())
-			if line != "" {
-				domains = append(domains, line)
+			if os.IsNotExist(err) {
+				fmt.Printf("File %s not found\n", fileName)
+			} else {
+				fmt.Printf("Failed to read %s: %v\n", fileName, err)
 			}
+			os.Exit(ExitFatal)
 		}
 
 		cyan.Print("[INFO] ")
-		red.Printf("%d ", len(domains))
+		red.Printf("%d ", len(targets))
 		fmt.Printf("domains in %s\n", fileName)
 
-		module.ProcessDomains(domains)
+		os.Exit(exitCode(module.ProcessTargets(targets)))
 	} else {
-		module.ProcessDomains([]string{domainInput})
+		os.Exit(exitCode(module.ProcessDomains([]string{domainInput})))
 	}
 }